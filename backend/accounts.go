@@ -15,19 +15,21 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"regexp"
+	"strings"
+	"time"
 
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/common/math"
-	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/crypto/sha3"
 )
@@ -35,6 +37,8 @@ import (
 const (
 	// InvalidAddress intends to prevent empty address_to
 	InvalidAddress string = "InvalidAddress"
+	// MaxBatchSize is the maximum number of transactions signed in one batch request
+	MaxBatchSize = 1000
 )
 
 // Account is an Ethereum account
@@ -42,6 +46,28 @@ type Account struct {
 	Address    string `json:"address"`
 	PrivateKey string `json:"private_key"`
 	PublicKey  string `json:"public_key"`
+	Name       string `json:"name,omitempty"`
+	// set for accounts derived from an HD wallet
+	Wallet         string `json:"wallet,omitempty"`
+	DerivationPath string `json:"derivation_path,omitempty"`
+	// restricts the transactions the account can sign, nil allows everything
+	Policy *Policy `json:"policy,omitempty"`
+	// the plugin allocates the nonces of the transactions, per chain
+	ManagedNonces bool `json:"managed_nonces,omitempty"`
+	// fixed at creation and can only be turned off, accounts created before the
+	// flag was introduced have none and remain exportable
+	Exportable *bool `json:"exportable,omitempty"`
+	// free-form metadata to tell which service the account belongs to
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+	// when, and by which entity, the account was created
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+// isExportable tells whether the private key of the account can be exported
+func (account *Account) isExportable() bool {
+	return account.Exportable == nil || *account.Exportable
 }
 
 func paths(b *backend) []*framework.Path {
@@ -49,7 +75,30 @@ func paths(b *backend) []*framework.Path {
 		pathCreateAndList(b),
 		pathReadAndDelete(b),
 		pathSign(b),
+		pathSignRawTx(b),
+		pathSignBatch(b),
+		pathSignMessage(b),
+		pathSignTypedData(b),
 		pathExport(b),
+		pathVerify(b),
+		pathWallets(b),
+		pathWallet(b),
+		pathWalletAccounts(b),
+		pathPolicy(b),
+		pathConfig(b),
+		pathLimits(b),
+		pathApprovals(b),
+		pathApproval(b),
+		pathNonces(b),
+		pathReleaseNonce(b),
+		pathWrappingKey(b),
+		pathDeletedAccounts(b),
+		pathDeletedAccount(b),
+		pathRestoreAccount(b),
+		pathPurgeAccount(b),
+		pathHistory(b),
+		pathLog(b),
+		pathDecode(b),
 	}
 }
 
@@ -59,65 +108,125 @@ func (b *backend) listAccounts(ctx context.Context, req *logical.Request, data *
 		b.Logger().Error("Failed to retrieve the list of accounts", "error", err)
 		return nil, err
 	}
+	labels := data.Get("labels").(map[string]string)
+
+	keys := make([]string, 0, len(vals))
+	keyInfo := map[string]interface{}{}
+	for _, address := range vals {
+		metadata, err := b.retrieveAccountMetadata(ctx, req.Storage, address)
+		if err != nil {
+			return nil, err
+		}
+		// skip the accounts deleted since they were listed
+		if metadata == nil || !metadata.hasLabels(labels) {
+			continue
+		}
+		keys = append(keys, address)
+		keyInfo[address] = accountInfo(metadata)
+	}
 
-	return logical.ListResponse(vals), nil
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
 }
 
 func (b *backend) createAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	keyInput := data.Get("privateKey").(string)
+	keystoreInput := data.Get("keystore").(string)
+	wrappedInput := data.Get("wrappedKey").(string)
 	var privateKey *ecdsa.PrivateKey
-	var privateKeyString string
 	var err error
 
+	if keyInput != "" && keystoreInput != "" {
+		return nil, fmt.Errorf("Only one of 'privateKey' or 'keystore' can be provided")
+	}
+	if wrappedInput != "" && (keyInput != "" || keystoreInput != "") {
+		return nil, fmt.Errorf("'wrappedKey' cannot be combined with 'privateKey' or 'keystore'")
+	}
+	imported := keyInput != "" || keystoreInput != "" || wrappedInput != ""
 	if keyInput != "" {
-    re := regexp.MustCompile("[0-9a-fA-F]{64}$")
-    key := re.FindString(keyInput)
-    if key == "" {
-      b.Logger().Error("Input private key did not parse successfully", "privateKey", keyInput)
-      return nil, fmt.Errorf("privateKey must be a 32-byte hexidecimal string")
-    }
+		re := regexp.MustCompile("[0-9a-fA-F]{64}$")
+		key := re.FindString(keyInput)
+		if key == "" {
+			b.Logger().Error("Input private key did not parse successfully", "privateKey", keyInput)
+			return nil, fmt.Errorf("privateKey must be a 32-byte hexidecimal string")
+		}
 		privateKey, err = crypto.HexToECDSA(key)
 		if err != nil {
 			b.Logger().Error("Error reconstructing private key from input hex", "error", err)
 			return nil, fmt.Errorf("Error reconstructing private key from input hex")
 		}
-		privateKeyString = key
-	} else {
+	}
+
+	if imported {
+		// checked before any decryption, so the mount can't be used to decrypt keys when
+		// imports are disabled
+		config, err := b.retrieveConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if !config.AllowImport {
+			return nil, fmt.Errorf("Importing private keys is disabled by the mount configuration")
+		}
+	}
+
+	switch {
+	case keyInput != "":
+		// parsed above
+	case keystoreInput != "":
+		key, err := keystore.DecryptKey([]byte(keystoreInput), data.Get("password").(string))
+		if err != nil {
+			b.Logger().Error("Failed to decrypt the input keystore", "error", err)
+			return nil, fmt.Errorf("Failed to decrypt the keystore: %v", err)
+		}
+		privateKey = key.PrivateKey
+	case wrappedInput != "":
+		privateKey, err = b.unwrapImportedKey(ctx, req.Storage, wrappedInput)
+		if err != nil {
+			return nil, err
+		}
+	default:
 		privateKey, _ = crypto.GenerateKey()
-		privateKeyBytes := crypto.FromECDSA(privateKey)
-		privateKeyString = hexutil.Encode(privateKeyBytes)[2:]
 	}
 
 	defer ZeroKey(privateKey)
 
-	publicKey := privateKey.Public()
-	publicKeyECDSA, _ := publicKey.(*ecdsa.PublicKey)
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	publicKeyString := hexutil.Encode(publicKeyBytes)[4:]
-
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(publicKeyBytes[1:])
-	address := hexutil.Encode(hash.Sum(nil)[12:])
+	accountJSON := accountFromKey(privateKey)
+	// generated keys never leave the vault unless asked for at creation
+	exportable := imported
+	if rawExportable, ok := data.GetOk("exportable"); ok {
+		exportable = rawExportable.(bool)
+	}
+	accountJSON.Exportable = &exportable
+	accountJSON.setCreator(req)
 
-	accountPath := fmt.Sprintf("accounts/%s", address)
+	defer b.lockAccount(accountJSON.Address)()
+	if imported {
+		existing, err := b.retrieveAccount(ctx, req, accountJSON.Address)
+		if err != nil {
+			b.Logger().Error("Failed to look up the imported account", "address", accountJSON.Address, "error", err)
+			return nil, err
+		}
+		if existing != nil {
+			// importing the same key again keeps the existing account and its settings
+			accountJSON = existing
+		}
+	}
 
-	accountJSON := &Account{
-		Address:    address,
-		PrivateKey: privateKeyString,
-		PublicKey:  publicKeyString,
+	if name := data.Get("name").(string); name != "" {
+		defer b.lockAccountName(name)()
+		if err := b.setAccountName(ctx, req.Storage, accountJSON, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := accountJSON.setMetadata(data); err != nil {
+		return nil, err
 	}
 
-	entry, _ := logical.StorageEntryJSON(accountPath, accountJSON)
-	err = req.Storage.Put(ctx, entry)
-	if err != nil {
-		b.Logger().Error("Failed to save the new account to storage", "error", err)
+	if err := b.saveAccount(ctx, req, accountJSON); err != nil {
 		return nil, err
 	}
 
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"address": accountJSON.Address,
-		},
+		Data: accountData(accountJSON),
 	}, nil
 }
 
@@ -133,9 +242,47 @@ func (b *backend) readAccount(ctx context.Context, req *logical.Request, data *f
 	}
 
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"address": account.Address,
-		},
+		Data: accountData(account),
+	}, nil
+}
+
+func (b *backend) updateAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, unlock, err := b.retrieveAccountForUpdate(ctx, req, nameOrAddress)
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+
+	if newName, ok := data.GetOk("newName"); ok {
+		defer b.lockAccountName(newName.(string))()
+		if err := b.setAccountName(ctx, req.Storage, account, newName.(string)); err != nil {
+			return nil, err
+		}
+	}
+	if managedNonces, ok := data.GetOk("managedNonces"); ok {
+		account.ManagedNonces = managedNonces.(bool)
+	}
+	if exportable, ok := data.GetOk("exportable"); ok {
+		if exportable.(bool) && !account.isExportable() {
+			return nil, fmt.Errorf("Accounts that are not exportable cannot be made exportable")
+		}
+		flag := exportable.(bool)
+		account.Exportable = &flag
+	}
+	if err := account.setMetadata(data); err != nil {
+		return nil, err
+	}
+
+	if err := b.saveAccount(ctx, req, account); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: accountData(account),
 	}, nil
 }
 
@@ -149,6 +296,9 @@ func (b *backend) exportAccount(ctx context.Context, req *logical.Request, data
 	if account == nil {
 		return nil, fmt.Errorf("Account does not exist")
 	}
+	if err := b.checkExportAllowed(ctx, req.Storage, account); err != nil {
+		return nil, err
+	}
 
 	return &logical.Response{
 		Data: map[string]interface{}{
@@ -158,66 +308,211 @@ func (b *backend) exportAccount(ctx context.Context, req *logical.Request, data
 	}, nil
 }
 
-func (b *backend) deleteAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+func (b *backend) exportAccountKeystore(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	address := data.Get("name").(string)
+	password := data.Get("password").(string)
+	if password == "" {
+		return nil, fmt.Errorf("'password' is required to export the account as a keystore")
+	}
+
+	b.Logger().Info("Retrieving account for address", "address", address)
 	account, err := b.retrieveAccount(ctx, req, address)
 	if err != nil {
-		b.Logger().Error("Failed to retrieve the account by address", "address", address, "error", err)
 		return nil, err
 	}
 	if account == nil {
-		return nil, nil
+		return nil, fmt.Errorf("Account does not exist")
 	}
-	if err := req.Storage.Delete(ctx, fmt.Sprintf("accounts/%s", account.Address)); err != nil {
-		b.Logger().Error("Failed to delete the account from storage", "address", address, "error", err)
+	if err := b.checkExportAllowed(ctx, req.Storage, account); err != nil {
 		return nil, err
 	}
-	return nil, nil
+
+	privateKey, err := crypto.HexToECDSA(account.PrivateKey)
+	if err != nil {
+		b.Logger().Error("Error reconstructing private key from retrieved hex", "error", err)
+		return nil, fmt.Errorf("Error reconstructing private key from retrieved hex")
+	}
+	defer ZeroKey(privateKey)
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	key := &keystore.Key{
+		Id:         id,
+		Address:    crypto.PubkeyToAddress(privateKey.PublicKey),
+		PrivateKey: privateKey,
+	}
+	scryptN, scryptP := keystore.StandardScryptN, keystore.StandardScryptP
+	if data.Get("lightKdf").(bool) {
+		scryptN, scryptP = keystore.LightScryptN, keystore.LightScryptP
+	}
+	keyJSON, err := keystore.EncryptKey(key, password, scryptN, scryptP)
+	if err != nil {
+		b.Logger().Error("Failed to encrypt the keystore", "error", err)
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"address":  account.Address,
+			"keystore": string(keyJSON),
+		},
+	}, nil
+}
+
+func (b *backend) accountExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
+	account, err := b.retrieveAccount(ctx, req, data.Get("name").(string))
+	if err != nil {
+		return false, err
+	}
+	return account != nil, nil
 }
 
+// retrieveAccount looks up an account by its address, with or without the "0x" prefix, or by its name
 func (b *backend) retrieveAccount(ctx context.Context, req *logical.Request, address string) (*Account, error) {
-	var path string
-	matched, err := regexp.MatchString("^(0x)?[0-9a-fA-F]{40}$", address)
-	if !matched || err != nil {
-		b.Logger().Error("Failed to retrieve the account, malformatted account address", "address", address, "error", err)
-		return nil, fmt.Errorf("Failed to retrieve the account, malformatted account address")
-	} else {
-		// make sure the address has the "0x prefix"
-		if address[:2] != "0x" {
-			address = "0x" + address
+	if !addressRegexp.MatchString(address) {
+		if !accountNameRegexp.MatchString(address) {
+			b.Logger().Error("Failed to retrieve the account, malformatted account address or name", "address", address)
+			return nil, fmt.Errorf("Failed to retrieve the account, malformatted account address or name")
 		}
-		path = fmt.Sprintf("accounts/%s", address)
-		entry, err := req.Storage.Get(ctx, path)
+		resolved, err := b.resolveAlias(ctx, req.Storage, address)
 		if err != nil {
-			b.Logger().Error("Failed to retrieve the account by address", "path", path, "error", err)
 			return nil, err
 		}
-		if entry == nil {
-			// could not find the corresponding key for the address
+		if resolved == "" {
+			// there is no account with the given name
 			return nil, nil
 		}
-		var account Account
-		_ = entry.DecodeJSON(&account)
-		return &account, nil
+		address = resolved
+	}
+	// make sure the address has the "0x prefix", and matches the lower case storage key
+	address = strings.ToLower(address)
+	if address[:2] != "0x" {
+		address = "0x" + address
+	}
+	path := fmt.Sprintf("accounts/%s", address)
+	entry, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the account by address", "path", path, "error", err)
+		return nil, err
 	}
+	if entry == nil {
+		// could not find the corresponding key for the address
+		return nil, nil
+	}
+	var account Account
+	_ = entry.DecodeJSON(&account)
+	return &account, nil
 }
 
-func (b *backend) signTx(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	from := data.Get("name").(string)
+// accountFromKey returns a new account record for the private key
+func accountFromKey(privateKey *ecdsa.PrivateKey) *Account {
+	privateKeyBytes := crypto.FromECDSA(privateKey)
+	publicKeyBytes := crypto.FromECDSAPub(&privateKey.PublicKey)
+
+	hash := sha3.NewLegacyKeccak256()
+	hash.Write(publicKeyBytes[1:])
+
+	return &Account{
+		Address:    hexutil.Encode(hash.Sum(nil)[12:]),
+		PrivateKey: hexutil.Encode(privateKeyBytes)[2:],
+		PublicKey:  hexutil.Encode(publicKeyBytes)[4:],
+	}
+}
+
+// saveAccount writes the account record to storage, with the lock of the account held
+func (b *backend) saveAccount(ctx context.Context, req *logical.Request, account *Account) error {
+	if !account.isExportable() {
+		// kept when the account is purged, so the key can't become exportable by deriving it again
+		entry, _ := logical.StorageEntryJSON(fmt.Sprintf("unexportable/%s", account.Address), map[string]bool{})
+		if err := req.Storage.Put(ctx, entry); err != nil {
+			b.Logger().Error("Failed to save the unexportable marker of the account", "address", account.Address, "error", err)
+			return err
+		}
+	}
+	entry, _ := logical.StorageEntryJSON(fmt.Sprintf("accounts/%s", account.Address), account)
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the account to storage", "address", account.Address, "error", err)
+		return err
+	}
+	return b.saveAccountMetadata(ctx, req.Storage, account)
+}
 
-	var txDataToSign []byte
-	dataInput := data.Get("data").(string)
-	// some client such as go-ethereum uses "input" instead of "data"
-	if dataInput == "" {
-		dataInput = data.Get("input").(string)
+// wasUnexportable tells whether the key of the address was ever held by an account that is
+// not exportable, even if the account has been purged since
+func (b *backend) wasUnexportable(ctx context.Context, storage logical.Storage, address string) (bool, error) {
+	marker, err := storage.Get(ctx, fmt.Sprintf("unexportable/%s", address))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the unexportable marker of the account", "address", address, "error", err)
+		return false, err
 	}
-	if len(dataInput) > 2 && dataInput[0:2] != "0x" {
-		dataInput = "0x" + dataInput
+	if marker != nil {
+		return true, nil
+	}
+	// the accounts deleted before the markers were recorded only have their tombstone
+	deleted, err := b.retrieveDeletedAccount(ctx, storage, address)
+	if err != nil {
+		return false, err
 	}
+	return deleted != nil && !deleted.Account.isExportable(), nil
+}
+
+// lockAccount serializes the updates of the account record, the returned function releases it
+func (b *backend) lockAccount(address string) func() {
+	lock := locksutil.LockForKey(b.accountLocks, strings.ToLower(address))
+	lock.Lock()
+	return lock.Unlock
+}
 
-	txDataToSign, err := hexutil.Decode(dataInput)
+// retrieveAccountForUpdate returns the account with its lock held, read again once the lock
+// is taken so the update starts from the latest record. The returned function releases the
+// lock, it must be called even if the account doesn't exist.
+func (b *backend) retrieveAccountForUpdate(ctx context.Context, req *logical.Request, nameOrAddress string) (*Account, func(), error) {
+	for {
+		account, err := b.retrieveAccount(ctx, req, nameOrAddress)
+		if err != nil || account == nil {
+			return nil, func() {}, err
+		}
+		unlock := b.lockAccount(account.Address)
+		current, err := b.retrieveAccount(ctx, req, nameOrAddress)
+		if err != nil {
+			unlock()
+			return nil, func() {}, err
+		}
+		if current != nil && current.Address == account.Address {
+			return current, unlock, nil
+		}
+		// the name moved to another account, or the account was deleted, before the lock was taken
+		unlock()
+	}
+}
+
+// accountData returns the public details of the account
+func accountData(account *Account) map[string]interface{} {
+	result := map[string]interface{}{
+		"address":    account.Address,
+		"exportable": account.isExportable(),
+	}
+	if account.Name != "" {
+		result["name"] = account.Name
+	}
+	if account.Wallet != "" {
+		result["wallet"] = account.Wallet
+		result["derivation_path"] = account.DerivationPath
+	}
+	if account.ManagedNonces {
+		result["managed_nonces"] = true
+	}
+	addMetadata(result, account.metadata())
+	return result
+}
+
+func (b *backend) signTx(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	from := data.Get("name").(string)
+
+	input, err := b.transactionInputFromFields(data)
 	if err != nil {
-		b.Logger().Error("Failed to decode payload for the 'data' field", "error", err)
 		return nil, err
 	}
 
@@ -229,80 +524,206 @@ func (b *backend) signTx(ctx context.Context, req *logical.Request, data *framew
 	if account == nil {
 		return nil, fmt.Errorf("Signing account %s does not exist", from)
 	}
-	amount := ValidNumber(data.Get("value").(string))
-	if amount == nil {
-		b.Logger().Error("Invalid amount for the 'value' field", "value", data.Get("value").(string))
-		return nil, fmt.Errorf("Invalid amount for the 'value' field")
+	if _, ok := data.GetOk("nonce"); ok && account.ManagedNonces {
+		return nil, fmt.Errorf("'nonce' cannot be provided, the nonces of account %s are managed by the plugin", account.Address)
 	}
 
-	rawAddressTo := data.Get("to").(string)
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
 
-	chainId := ValidNumber(data.Get("chainId").(string))
-	if chainId == nil {
-		b.Logger().Error("Invalid chainId", "chainId", data.Get("chainId").(string))
-		return nil, fmt.Errorf("Invalid 'chainId' value")
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	tx, chainId, err := input.transaction(config)
+	if err != nil {
+		return nil, err
 	}
 
-	gasLimitIn := ValidNumber(data.Get("gas").(string))
-	if gasLimitIn == nil {
-		b.Logger().Error("Invalid gas limit", "gas", data.Get("gas").(string))
-		return nil, fmt.Errorf("Invalid gas limit")
+	return b.signTransaction(ctx, req, account, privateKey, tx, chainId)
+}
+
+func (b *backend) signRawTx(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	from := data.Get("name").(string)
+
+	rawInput := data.Get("transaction").(string)
+	if rawInput == "" {
+		return nil, fmt.Errorf("'transaction' is required")
+	}
+	raw, err := decodeRawTransaction(rawInput)
+	if err != nil {
+		b.Logger().Error("Failed to decode the raw transaction to sign", "error", err)
+		return nil, err
+	}
+	if raw.signed != nil {
+		return nil, fmt.Errorf("The transaction is already signed")
 	}
-	gasLimit := gasLimitIn.Uint64()
 
-	gasPrice := ValidNumber(data.Get("gasPrice").(string))
+	account, err := b.retrieveAccount(ctx, req, from)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing account", "address", from, "error", err)
+		return nil, fmt.Errorf("Error retrieving signing account %s", from)
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Signing account %s does not exist", from)
+	}
+	// the nonce is part of the pre-built transaction, and can't be replaced by the plugin
+	if account.ManagedNonces {
+		return nil, fmt.Errorf("Raw transactions cannot be signed by account %s, its nonces are managed by the plugin", account.Address)
+	}
 
-	privateKey, err := crypto.HexToECDSA(account.PrivateKey)
+	privateKey, err := b.accountKey(account)
 	if err != nil {
-		b.Logger().Error("Error reconstructing private key from retrieved hex", "error", err)
-		return nil, fmt.Errorf("Error reconstructing private key from retrieved hex")
+		return nil, err
 	}
 	defer ZeroKey(privateKey)
 
-	nonceIn := ValidNumber(data.Get("nonce").(string))
-	var nonce uint64
-	nonce = nonceIn.Uint64()
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.checkDataSize("data", len(raw.tx.Data())); err != nil {
+		return nil, err
+	}
+	chainId := raw.chainId
+	if chainId == nil {
+		chainId, _ = new(big.Int).SetString(config.DefaultChainID, 10)
+	}
+
+	return b.signTransaction(ctx, req, account, privateKey, raw.tx, chainId)
+}
+
+func (b *backend) signBatch(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	from := data.Get("name").(string)
 
-	var tx *types.Transaction
-	if rawAddressTo == "" {
-		tx = types.NewContractCreation(nonce, amount, gasLimit, gasPrice, txDataToSign)
-	} else {
-		toAddress := common.HexToAddress(rawAddressTo)
-		tx = types.NewTransaction(nonce, toAddress, amount, gasLimit, gasPrice, txDataToSign)
+	items, err := batchItems(data)
+	if err != nil {
+		return nil, err
 	}
-	var signer types.Signer
-	if big.NewInt(0).Cmp(chainId) == 0 {
-		signer = types.HomesteadSigner{}
-	} else {
-		signer = types.NewEIP155Signer(chainId)
+
+	account, err := b.retrieveAccount(ctx, req, from)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing account", "address", from, "error", err)
+		return nil, fmt.Errorf("Error retrieving signing account %s", from)
 	}
-	signedTx, err := types.SignTx(tx, signer, privateKey)
+	if account == nil {
+		return nil, fmt.Errorf("Signing account %s does not exist", from)
+	}
+
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
+
+	config, err := b.retrieveConfig(ctx, req.Storage)
 	if err != nil {
-		b.Logger().Error("Failed to sign the transaction object", "error", err)
 		return nil, err
 	}
 
-	var signedTxBuff bytes.Buffer
-	signedTx.EncodeRLP(&signedTxBuff)
+	results := make([]map[string]interface{}, len(items))
+	signed, pending, failed := 0, 0, 0
+	for i, item := range items {
+		result, err := b.signBatchItem(ctx, req, account, privateKey, config, item)
+		switch {
+		case err != nil:
+			result = map[string]interface{}{"error": err.Error()}
+			failed++
+		case result["approval_id"] != nil:
+			// accounts that require approvals only create an approval request
+			pending++
+		default:
+			signed++
+		}
+		results[i] = result
+	}
 
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"transaction_hash":   signedTx.Hash().Hex(),
-			"signed_transaction": hexutil.Encode(signedTxBuff.Bytes()),
+			"results": results,
+			"signed":  signed,
+			"pending": pending,
+			"failed":  failed,
 		},
 	}, nil
 }
 
-func ValidNumber(input string) *big.Int {
+// signBatchItem signs one of the transactions of a batch, described by the same fields as the
+// sign endpoint, and returns the same data as the sign endpoint
+func (b *backend) signBatchItem(ctx context.Context, req *logical.Request, account *Account, privateKey *ecdsa.PrivateKey, config *Config, item interface{}) (map[string]interface{}, error) {
+	raw, ok := item.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Each transaction must be an object")
+	}
+	data := &framework.FieldData{
+		Raw:    raw,
+		Schema: transactionFields(map[string]*framework.FieldSchema{}),
+	}
+	if err := data.Validate(); err != nil {
+		return nil, err
+	}
+	input, err := b.transactionInputFromFields(data)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := data.GetOk("nonce"); ok && account.ManagedNonces {
+		return nil, fmt.Errorf("'nonce' cannot be provided, the nonces of account %s are managed by the plugin", account.Address)
+	}
+	tx, chainId, err := input.transaction(config)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.signTransaction(ctx, req, account, privateKey, tx, chainId)
+	if err == logical.ErrPermissionDenied && resp != nil {
+		// policy violations are returned as error responses
+		return nil, fmt.Errorf("%s", resp.Data["error"])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// batchItems returns the transactions of a batch, passed either as an array or as a JSON string
+func batchItems(data *framework.FieldData) ([]interface{}, error) {
+	items, err := jsonListInput("transactions", data.Get("transactions").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("'transactions' is required")
+	}
+	if len(items) > MaxBatchSize {
+		return nil, fmt.Errorf("At most %d transactions can be signed in one batch", MaxBatchSize)
+	}
+	return items, nil
+}
+
+// accountKey reconstructs the private key of the account, which must be zeroed after use
+func (b *backend) accountKey(account *Account) (*ecdsa.PrivateKey, error) {
+	privateKey, err := crypto.HexToECDSA(account.PrivateKey)
+	if err != nil {
+		b.Logger().Error("Error reconstructing private key from retrieved hex", "error", err)
+		return nil, fmt.Errorf("Error reconstructing private key from retrieved hex")
+	}
+	return privateKey, nil
+}
+
+// ValidNumber parses a non-negative decimal or 0x-prefixed hexadecimal number of at most 256
+// bits, an empty input is zero
+func ValidNumber(input string) (*big.Int, error) {
 	if input == "" {
-		return big.NewInt(0)
+		return big.NewInt(0), nil
 	}
-	matched, err := regexp.MatchString("([0-9])", input)
-	if !matched || err != nil {
-		return nil
+	amount, ok := math.ParseBig256(input)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("Invalid number %s", input)
 	}
-	amount := math.MustParseBig256(input)
-	return amount.Abs(amount)
+	return amount, nil
 }
 
 func ZeroKey(k *ecdsa.PrivateKey) {
@@ -24,8 +24,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	log "github.com/hashicorp/go-hclog"
@@ -102,6 +104,7 @@ func TestAccounts(t *testing.T) {
 	}
 
 	address1 := res.Data["address"].(string)
+	createdAt1 := res.Data["created_at"].(string)
 
 	// create key2
 	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
@@ -120,25 +123,15 @@ func TestAccounts(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	expected1 := &logical.Response{
-		Data: map[string]interface{}{
-			"keys": []string{address1, address2},
-		},
-	}
-	expected2 := &logical.Response{
-		Data: map[string]interface{}{
-			"keys": []string{address2, address1},
-		},
-	}
-
-	if !reflect.DeepEqual(resp, expected1) && !reflect.DeepEqual(resp, expected2) {
-		t.Fatalf("bad response.\n\nexpected: %#v\n\nGot: %#v", expected1, resp)
-	}
+	assert.ElementsMatch([]string{address1, address2}, resp.Data["keys"])
+	assert.Equal(map[string]interface{}{"created_at": createdAt1}, resp.Data["key_info"].(map[string]interface{})[address1])
 
 	// read account by address
 	expected := &logical.Response{
 		Data: map[string]interface{}{
-			"address": address1,
+			"address":    address1,
+			"created_at": createdAt1,
+			"exportable": false,
 		},
 	}
 	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+address1)
@@ -303,7 +296,7 @@ func TestAccounts(t *testing.T) {
   req = logical.TestRequest(t, logical.ListOperation, "accounts")
   req.Storage = storage
   resp, _ = b.HandleRequest(context.Background(), req)
-  assert.Equal(1, len(resp.Data))
+  assert.Equal([]string{address4}, resp.Data["keys"])
 }
 
 func TestListAccountsFailure1(t *testing.T) {
@@ -465,7 +458,7 @@ func TestDeleteAccountsFailure2(t *testing.T) {
 	resp, err := b.HandleRequest(context.Background(), req)
 
 	assert.Nil(resp)
-	assert.Nil(err)
+	assert.Equal("Account does not exist", err.Error())
 }
 
 func TestDeleteAccountsFailure3(t *testing.T) {
@@ -479,7 +472,7 @@ func TestDeleteAccountsFailure3(t *testing.T) {
 	resp, err := b.HandleRequest(context.Background(), req)
 
 	assert.Nil(resp)
-	assert.Equal("Bang for Delete!", err.Error())
+	assert.Equal("Bang for Put!", err.Error())
 }
 
 func TestSignTxFailure1(t *testing.T) {
@@ -588,6 +581,392 @@ func TestSignTxFailure7(t *testing.T) {
 	assert.Equal("Error reconstructing private key from retrieved hex", err.Error())
 }
 
+func TestSignDynamicFeeTx(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _ := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	storage := req.Storage
+	res, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	address := res.Data["address"].(string)
+
+	// the transaction type is inferred from the EIP-1559 fee fields
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+address+"/sign")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"data":                 "60fe47b10000000000000000000000000000000000000000000000000000000000000014",
+		"to":                   "0xf809410b0d6f047c603deb311979cd413e025a84",
+		"gas":                  50000,
+		"nonce":                "0x3",
+		"maxFeePerGas":         "2000000000",
+		"maxPriorityFeePerGas": "1000000000",
+		"chainId":              12345,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signedTx := resp.Data["signed_transaction"].(string)
+	assert.True(strings.HasPrefix(signedTx, "0x02"))
+	txBytes, _ := hexutil.Decode(signedTx)
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(txBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(uint8(types.DynamicFeeTxType), tx.Type())
+	assert.Equal(big.NewInt(12345), tx.ChainId())
+	assert.Equal(big.NewInt(2000000000), tx.GasFeeCap())
+	assert.Equal(big.NewInt(1000000000), tx.GasTipCap())
+	assert.Equal(uint64(3), tx.Nonce())
+	assert.Equal(resp.Data["transaction_hash"], tx.Hash().Hex())
+	sender, err := types.Sender(types.NewLondonSigner(big.NewInt(12345)), &tx)
+	assert.Nil(err)
+	assert.Equal(address, strings.ToLower(sender.Hex()))
+
+	// explicit type with contract creation
+	req.Data = map[string]interface{}{
+		"data":         "0x6080",
+		"nonce":        "0x4",
+		"type":         "0x2",
+		"maxFeePerGas": "1000",
+		"chainId":      12345,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	var tx2 types.Transaction
+	if err := tx2.UnmarshalBinary(txBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Nil(tx2.To())
+	assert.Equal(0, tx2.GasTipCap().Sign())
+
+	// explicit legacy type keeps the plain RLP encoding
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"to":      "0xf809410b0d6f047c603deb311979cd413e025a84",
+		"type":    "0x0",
+		"chainId": 12345,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	var tx3 types.Transaction
+	err = tx3.DecodeRLP(rlp.NewStream(bytes.NewReader(txBytes), 0))
+	assert.Nil(err)
+	assert.Equal(uint8(types.LegacyTxType), tx3.Type())
+
+	// failures
+	req.Data = map[string]interface{}{
+		"data":         "0x",
+		"maxFeePerGas": "1000",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'chainId' is required for EIP-1559 transactions", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"type":    "0x2",
+		"chainId": 12345,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'maxFeePerGas' is required for EIP-1559 transactions", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":                 "0x",
+		"maxFeePerGas":         "1000",
+		"maxPriorityFeePerGas": "1001",
+		"chainId":              12345,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'maxPriorityFeePerGas' cannot be higher than 'maxFeePerGas'", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":         "0x",
+		"maxFeePerGas": "1000",
+		"gasPrice":     "1000",
+		"chainId":      12345,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'gasPrice' cannot be used with EIP-1559 transactions, use 'maxFeePerGas' and 'maxPriorityFeePerGas' instead", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"type":    "0x5",
+		"chainId": 12345,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Unsupported transaction type 0x5", err.Error())
+
+	// the fee fields must match the transaction type
+	for _, txType := range []string{"0x0", "0x1"} {
+		req.Data = map[string]interface{}{
+			"data":                 "0x",
+			"type":                 txType,
+			"maxPriorityFeePerGas": "1000",
+			"chainId":              12345,
+		}
+		_, err = b.HandleRequest(context.Background(), req)
+		assert.Equal("'maxFeePerGas' and 'maxPriorityFeePerGas' can only be used with EIP-1559 transactions", err.Error(), txType)
+	}
+	req.Data = map[string]interface{}{
+		"data":     "0x",
+		"type":     "0x2",
+		"gasPrice": "1000",
+		"chainId":  12345,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'gasPrice' cannot be used with EIP-1559 transactions, use 'maxFeePerGas' and 'maxPriorityFeePerGas' instead", err.Error())
+
+	// gas limits past 64 bits would wrap around
+	req.Data = map[string]interface{}{
+		"data": "0x",
+		"gas":  "18446744073709551617",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid gas limit", err.Error())
+
+	// malformed numbers are rejected, rather than failing the request
+	invalid := map[string]string{
+		"maxFeePerGas":         "Invalid 'maxFeePerGas' value",
+		"maxPriorityFeePerGas": "Invalid 'maxPriorityFeePerGas' value",
+		"type":                 "Invalid 'type' value",
+		"nonce":                "Invalid 'nonce' value",
+	}
+	for field, message := range invalid {
+		req.Data = map[string]interface{}{
+			"data":                 "0x",
+			"maxFeePerGas":         "1000",
+			"maxPriorityFeePerGas": "1000",
+			"chainId":              12345,
+		}
+		req.Data[field] = "12abc"
+		_, err = b.HandleRequest(context.Background(), req)
+		assert.Equal(message, err.Error(), field)
+	}
+	req.Data = map[string]interface{}{
+		"data":     "0x",
+		"gasPrice": "0x" + strings.Repeat("f", 65),
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid 'gasPrice' value", err.Error())
+
+	// negative amounts are rejected instead of having their sign flipped
+	req.Data = map[string]interface{}{
+		"data":  "0x",
+		"value": "-1000",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid amount for the 'value' field", err.Error())
+}
+
+func TestSignAccessListTx(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _ := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	storage := req.Storage
+	res, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	address := res.Data["address"].(string)
+
+	accessList := []interface{}{
+		map[string]interface{}{
+			"address": "0xf809410b0d6f047c603deb311979cd413e025a84",
+			"storageKeys": []interface{}{
+				"0x0000000000000000000000000000000000000000000000000000000000000001",
+			},
+		},
+	}
+
+	// an access list without the EIP-1559 fee fields produces a type-1 transaction
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+address+"/sign")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"data":       "0x60fe47b10000000000000000000000000000000000000000000000000000000000000014",
+		"to":         "0xf809410b0d6f047c603deb311979cd413e025a84",
+		"gas":        50000,
+		"nonce":      "0x1",
+		"gasPrice":   "1000",
+		"chainId":    12345,
+		"accessList": accessList,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signedTx := resp.Data["signed_transaction"].(string)
+	assert.True(strings.HasPrefix(signedTx, "0x01"))
+	txBytes, _ := hexutil.Decode(signedTx)
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(txBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(uint8(types.AccessListTxType), tx.Type())
+	assert.Equal(big.NewInt(1000), tx.GasPrice())
+	assert.Equal(1, len(tx.AccessList()))
+	assert.Equal(1, tx.AccessList().StorageKeys())
+	sender, err := types.Sender(types.NewEIP2930Signer(big.NewInt(12345)), &tx)
+	assert.Nil(err)
+	assert.Equal(address, strings.ToLower(sender.Hex()))
+
+	// the access list can also be attached to EIP-1559 transactions,
+	// here passed as a JSON string like the command line does
+	req.Data = map[string]interface{}{
+		"data":         "0x",
+		"to":           "0xf809410b0d6f047c603deb311979cd413e025a84",
+		"maxFeePerGas": "1000",
+		"chainId":      12345,
+		"accessList":   `[{"address":"0xf809410b0d6f047c603deb311979cd413e025a84","storageKeys":[]}]`,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	var tx2 types.Transaction
+	if err := tx2.UnmarshalBinary(txBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(uint8(types.DynamicFeeTxType), tx2.Type())
+	assert.Equal(1, len(tx2.AccessList()))
+
+	// failures
+	req.Data = map[string]interface{}{
+		"data":       "0x",
+		"accessList": accessList,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'chainId' is required for EIP-2930 transactions", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":       "0x",
+		"type":       "0x0",
+		"accessList": accessList,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'accessList' cannot be used with legacy transactions", err.Error())
+
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"chainId": 12345,
+		"accessList": []interface{}{
+			map[string]interface{}{"address": "0xabc"},
+		},
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.True(strings.HasPrefix(err.Error(), "Invalid 'accessList' value"))
+}
+
+// test vectors from the Web3 Secret Storage definition
+const scryptKeystore = `{"crypto":{"cipher":"aes-128-ctr","cipherparams":{"iv":"83dbcc02d8ccb40e466191a123791e0e"},"ciphertext":"d172bf743a674da9cdad04534d56926ef8358534d458fffccd4e6ad2fbde479c","kdf":"scrypt","kdfparams":{"dklen":32,"n":262144,"r":1,"p":8,"salt":"ab0c7876052600dd703518d6fc3fe8984592145b591fc8fb5c6d43190334ba19"},"mac":"2103ac29920d71da29f15d75b4a16dbe95cfd7ff8faea1056c33131d846e3097"},"id":"3198bc9c-6672-5ab3-d995-4942343ae5b6","version":3}`
+const pbkdf2Keystore = `{"crypto":{"cipher":"aes-128-ctr","cipherparams":{"iv":"6087dab2f9fdbbfaddc31a909735c1e6"},"ciphertext":"5318b4d5bcd28de64ee5559e671353e16f075ecae9f99c7a79a38af5f869aa46","kdf":"pbkdf2","kdfparams":{"c":262144,"dklen":32,"prf":"hmac-sha256","salt":"ae3cd4e7013836a3df6bd7241b12db061dbe2c6785853cce422d148a624ce0bd"},"mac":"517ead924a9d0dc3124507e3393d175ce3ff7c1e96529c6c555ce9e51205e9b2"},"id":"3198bc9c-6672-5ab3-d995-4942343ae5b6","version":3}`
+
+func TestKeystoreImportExport(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	for _, keyJSON := range []string{scryptKeystore, pbkdf2Keystore} {
+		req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+		req.Storage = storage
+		req.Data = map[string]interface{}{
+			"keystore": keyJSON,
+			"password": "testpassword",
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		assert.Equal("0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b", resp.Data["address"])
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "export/accounts/0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d", resp.Data["privateKey"])
+
+	// export as a keystore, and decrypt it with the same password
+	req = logical.TestRequest(t, logical.CreateOperation, "export/accounts/0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"password": "n3w-passw0rd",
+		"lightKdf": true,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Nil(resp.Data["privateKey"])
+	keyJSON := resp.Data["keystore"].(string)
+	key, err := keystore.DecryptKey([]byte(keyJSON), "n3w-passw0rd")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b", strings.ToLower(key.Address.Hex()))
+	assert.Equal("7a28b5ba57c53603b0b07b56bba752f7784bf506fa95edc395f5cf6c7514fe9d", hexutil.Encode(crypto.FromECDSA(key.PrivateKey))[2:])
+
+	// the exported keystore can be imported again
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"keystore": keyJSON,
+		"password": "n3w-passw0rd",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x008aeeda4d805471df9b2a5b0f38a0c3bcba786b", resp.Data["address"])
+}
+
+func TestKeystoreFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"keystore": pbkdf2Keystore,
+		"password": "wrongpassword",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Failed to decrypt the keystore: could not decrypt key with given password", err.Error())
+
+	req.Data = map[string]interface{}{
+		"keystore":   pbkdf2Keystore,
+		"privateKey": testPrivateKey,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Only one of 'privateKey' or 'keystore' can be provided", err.Error())
+
+	req = logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'password' is required to export the account as a keystore", err.Error())
+
+	req.Data = map[string]interface{}{
+		"password": "password",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account does not exist", err.Error())
+}
+
 func contains(arr []*big.Int, value *big.Int) bool {
 	for _, a := range arr {
 		if a.Cmp(value) == 0 {
@@ -596,3 +975,87 @@ func contains(arr []*big.Int, value *big.Int) bool {
 	}
 	return false
 }
+
+func TestExportable(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// generated keys are not exportable by default
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	generated := resp.Data["address"].(string)
+	assert.Equal(false, resp.Data["exportable"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+generated)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+generated+" is not exportable", err.Error())
+
+	req = logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+generated)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"password": "secret",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+generated+" is not exportable", err.Error())
+
+	// the flag cannot be turned back on
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+generated)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"exportable": true,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Accounts that are not exportable cannot be made exportable", err.Error())
+
+	// imported keys are exportable by default, until turned off
+	importTestAccount(t, b, storage)
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(true, resp.Data["exportable"])
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"exportable": false,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(false, resp.Data["exportable"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+testAddress+" is not exportable", err.Error())
+
+	// importing the key again does not make it exportable
+	importTestAccount(t, b, storage)
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+testAddress+" is not exportable", err.Error())
+
+	// imported keys can be made non-exportable from the start
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": "8d2e1e3e4b0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b",
+		"exportable": false,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(false, resp.Data["exportable"])
+}
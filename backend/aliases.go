@@ -0,0 +1,124 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var (
+	addressRegexp     = regexp.MustCompile("^(0x)?[0-9a-fA-F]{40}$")
+	accountNameRegexp = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+)
+
+// Alias is the index entry that maps a human-readable account name to the account address
+type Alias struct {
+	Address string `json:"address"`
+}
+
+// validateAccountName makes sure the name can be used in the account paths, and can never
+// be confused with an address
+func validateAccountName(name string) error {
+	if !accountNameRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid account name %s, must only contain letters, digits, '_', '-' and '.'", name)
+	}
+	if addressRegexp.MatchString(name) {
+		return fmt.Errorf("Invalid account name %s, names must not be formatted as addresses", name)
+	}
+	return nil
+}
+
+// resolveAlias returns the address of the account with the given name, or "" if there's none
+func (b *backend) resolveAlias(ctx context.Context, storage logical.Storage, name string) (string, error) {
+	path := fmt.Sprintf("aliases/%s", name)
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the account name", "path", path, "error", err)
+		return "", err
+	}
+	if entry == nil {
+		return "", nil
+	}
+	var alias Alias
+	if err := entry.DecodeJSON(&alias); err != nil {
+		return "", err
+	}
+	return alias.Address, nil
+}
+
+// lockAccountName serializes the claims of the name, the returned function releases it. The
+// lock must be held from setAccountName until the account is saved, for the name to be
+// claimed by a single account
+func (b *backend) lockAccountName(name string) func() {
+	lock := locksutil.LockForKey(b.nameLocks, name)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// accountNameInUse tells whether the name belongs to an account other than the given address
+func (b *backend) accountNameInUse(ctx context.Context, storage logical.Storage, name, address string) (bool, error) {
+	owner, err := b.resolveAlias(ctx, storage, name)
+	if err != nil {
+		return false, err
+	}
+	if owner == "" || owner == address {
+		return false, nil
+	}
+	entry, err := storage.Get(ctx, fmt.Sprintf("accounts/%s", owner))
+	if err != nil {
+		return false, err
+	}
+	// an alias left behind by a failed write does not hold on to the name
+	return entry != nil, nil
+}
+
+// setAccountName points the name to the account, and releases the previous name of the
+// account. An empty name just removes the current name. The account itself must be
+// saved by the caller, while holding the lock of the name.
+func (b *backend) setAccountName(ctx context.Context, storage logical.Storage, account *Account, name string) error {
+	if name == account.Name {
+		return nil
+	}
+	if name != "" {
+		if err := validateAccountName(name); err != nil {
+			return err
+		}
+		inUse, err := b.accountNameInUse(ctx, storage, name, account.Address)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return fmt.Errorf("Account name %s is already in use", name)
+		}
+		entry, _ := logical.StorageEntryJSON(fmt.Sprintf("aliases/%s", name), &Alias{Address: account.Address})
+		if err := storage.Put(ctx, entry); err != nil {
+			b.Logger().Error("Failed to save the account name", "name", name, "error", err)
+			return err
+		}
+	}
+	if account.Name != "" {
+		if err := storage.Delete(ctx, fmt.Sprintf("aliases/%s", account.Name)); err != nil {
+			b.Logger().Error("Failed to release the previous account name", "name", account.Name, "error", err)
+			return err
+		}
+	}
+	account.Name = name
+	return nil
+}
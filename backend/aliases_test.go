@@ -0,0 +1,245 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountNames(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// create an account with a name
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":       "payments-hot",
+		"exportable": true,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	address := resp.Data["address"].(string)
+	assert.Equal("payments-hot", resp.Data["name"])
+
+	// the name must be unique
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account name payments-hot is already in use", err.Error())
+
+	// read by name, and by the checksummed address
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/payments-hot")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(address, resp.Data["address"])
+	assert.Equal("payments-hot", resp.Data["name"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+strings.ToUpper(address[2:]))
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("payments-hot", resp.Data["name"])
+
+	// sign and export by name
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/payments-hot/sign")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"data": "0x",
+		"to":   "0xf809410b0d6f047c603deb311979cd413e025a84",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/payments-hot")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(address, resp.Data["address"])
+
+	// rename the account, the old name is released
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/payments-hot")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "payments-warm",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("payments-warm", resp.Data["name"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/payments-hot")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account does not exist", err.Error())
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/payments-warm")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(address, resp.Data["address"])
+
+	// re-importing the key of a named account keeps the name
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": testPrivateKey,
+		"name":       "payments-cold",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req.Data = map[string]interface{}{
+		"privateKey": testPrivateKey,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("payments-cold", resp.Data["name"])
+
+	// the name of another account can not be taken
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/payments-cold")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "payments-warm",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account name payments-warm is already in use", err.Error())
+
+	// remove the name
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/payments-cold")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Nil(resp.Data["name"])
+
+	// deleting an account releases the name
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/payments-warm")
+	req.Storage = storage
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	entry, _ := storage.Get(context.Background(), "aliases/payments-warm")
+	assert.Nil(entry)
+
+	// updating a missing account is not supported
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/payments-warm")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "payments",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.NotNil(err)
+}
+
+func TestAccountNamesFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name": "0xf809410b0d6f047c603deb311979cd413e025a84",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid account name 0xf809410b0d6f047c603deb311979cd413e025a84, names must not be formatted as addresses", err.Error())
+
+	req.Data = map[string]interface{}{
+		"name": "bad name",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid account name bad name, must only contain letters, digits, '_', '-' and '.'", err.Error())
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/key1")
+	sm := newStorageMock()
+	req.Storage = sm
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Bang for Get!", err.Error())
+}
+
+// slowStorage widens the gap between the reads and writes of the concurrent requests
+type slowStorage struct {
+	logical.Storage
+}
+
+func (s *slowStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	time.Sleep(10 * time.Millisecond)
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestAccountNamesConcurrent(t *testing.T) {
+	assert := assert.New(t)
+
+	b, inmem := getBackend(t)
+	storage := &slowStorage{inmem}
+
+	// only one of the accounts created at the same time with the same name gets it
+	var wg sync.WaitGroup
+	addresses := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+			req.Storage = storage
+			req.Data = map[string]interface{}{
+				"name": "treasury",
+			}
+			if resp, err := b.HandleRequest(context.Background(), req); err == nil {
+				addresses <- resp.Data["address"].(string)
+			} else {
+				assert.Equal("Account name treasury is already in use", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	close(addresses)
+	assert.Equal(1, len(addresses))
+
+	req := logical.TestRequest(t, logical.ReadOperation, "accounts/treasury")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(<-addresses, resp.Data["address"])
+}
@@ -0,0 +1,366 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/google/uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// DefaultApprovalTTL is how long an approval request stays open when the policy doesn't say
+const DefaultApprovalTTL = 24 * time.Hour
+
+// Approval request statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalFailed   = "failed"
+	ApprovalExpired  = "expired"
+)
+
+// ApprovalRequest is a transaction waiting for the approvals required by the account policy
+// before it is signed
+type ApprovalRequest struct {
+	ID      string `json:"id"`
+	Account string `json:"account"`
+	// unsigned transaction, in the same encoding as the signed one
+	Transaction string `json:"transaction"`
+	ChainID     string `json:"chain_id"`
+	RequestedBy string `json:"requested_by"`
+	// the nonce is allocated by the plugin when the transaction is signed, the nonce of the
+	// unsigned transaction is only a placeholder
+	ManagedNonce bool      `json:"managed_nonce,omitempty"`
+	Approvals    []string  `json:"approvals"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// set once the quorum is reached
+	SignedTransaction string `json:"signed_transaction,omitempty"`
+	TransactionHash   string `json:"transaction_hash,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+func (r *ApprovalRequest) status(now time.Time) string {
+	switch {
+	case r.SignedTransaction != "":
+		return ApprovalApproved
+	case r.Error != "":
+		return ApprovalFailed
+	case now.After(r.ExpiresAt):
+		return ApprovalExpired
+	default:
+		return ApprovalPending
+	}
+}
+
+// requiresApproval tells whether transactions need approvals before they are signed
+func (p *Policy) requiresApproval() bool {
+	return p != nil && p.RequiredApprovals > 0
+}
+
+// approvalTTL returns how long the approval requests of the account stay open
+func (p *Policy) approvalTTL() time.Duration {
+	if p.ApprovalTTL > 0 {
+		return time.Duration(p.ApprovalTTL) * time.Second
+	}
+	return DefaultApprovalTTL
+}
+
+// requestApproval saves the transaction as a pending approval request, and returns its ID
+func (b *backend) requestApproval(ctx context.Context, req *logical.Request, account *Account, tx *types.Transaction, chainId *big.Int) (*logical.Response, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	txBytes, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	request := &ApprovalRequest{
+		ID:           id.String(),
+		Account:      account.Address,
+		Transaction:  hexutil.Encode(txBytes),
+		ChainID:      chainId.String(),
+		RequestedBy:  req.EntityID,
+		ManagedNonce: account.ManagedNonces,
+		Approvals:    []string{},
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(account.Policy.approvalTTL()),
+	}
+	if err := b.saveApprovalRequest(ctx, req.Storage, request); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("Transaction is pending approval", "address", account.Address, "id", request.ID)
+
+	return &logical.Response{
+		Data: b.approvalData(request, account.Policy.RequiredApprovals),
+	}, nil
+}
+
+func (b *backend) listApprovals(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "approvals/")
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the list of approval requests", "error", err)
+		return nil, err
+	}
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) readApproval(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	request, err := b.retrieveApprovalRequest(ctx, req.Storage, data.Get("id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, nil
+	}
+	account, err := b.retrieveAccount(ctx, req, request.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	required := 0
+	if account != nil && account.Policy != nil {
+		required = account.Policy.RequiredApprovals
+	}
+	return &logical.Response{
+		Data: b.approvalData(request, required),
+	}, nil
+}
+
+// approve records the approval of the caller, and signs the transaction once the number of
+// approvals required by the account policy is reached
+func (b *backend) approve(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+	approver := req.EntityID
+	if approver == "" {
+		return nil, fmt.Errorf("Approvals can only be given by callers with an identity entity")
+	}
+
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	request, err := b.retrieveApprovalRequest(ctx, req.Storage, id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, fmt.Errorf("Approval request does not exist")
+	}
+	if status := request.status(time.Now()); status != ApprovalPending {
+		return nil, fmt.Errorf("Approval request is %s", status)
+	}
+	account, err := b.retrieveAccount(ctx, req, request.Account)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if !account.Policy.requiresApproval() {
+		return nil, fmt.Errorf("The account policy no longer requires approvals, the transaction must be signed again")
+	}
+	if request.ManagedNonce && !account.ManagedNonces {
+		// the transaction only has a placeholder nonce
+		return nil, fmt.Errorf("The nonces of the account are no longer managed by the plugin, the transaction must be signed again")
+	}
+	policy := account.Policy
+
+	if approver == request.RequestedBy {
+		return nil, fmt.Errorf("Approval requests cannot be approved by the requester")
+	}
+	if len(policy.Approvers) > 0 && !containsString(policy.Approvers, approver) {
+		return nil, logical.ErrPermissionDenied
+	}
+	if containsString(request.Approvals, approver) {
+		return nil, fmt.Errorf("Approval request was already approved by %s", approver)
+	}
+	request.Approvals = append(request.Approvals, approver)
+	b.Logger().Info("Transaction approved", "id", id, "approver", approver)
+
+	if len(request.Approvals) >= policy.RequiredApprovals {
+		if err := b.signApprovedTransaction(ctx, req.Storage, account, request); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.saveApprovalRequest(ctx, req.Storage, request); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: b.approvalData(request, policy.RequiredApprovals),
+	}, nil
+}
+
+// signApprovedTransaction checks the transaction again under the current policy, in case it
+// changed since the request was made, then signs it. A transaction that is now rejected fails
+// the request
+func (b *backend) signApprovedTransaction(ctx context.Context, storage logical.Storage, account *Account, request *ApprovalRequest) error {
+	txBytes, err := hexutil.Decode(request.Transaction)
+	if err != nil {
+		return err
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(txBytes); err != nil {
+		return err
+	}
+	chainId, _ := new(big.Int).SetString(request.ChainID, 10)
+
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return err
+	}
+	defer ZeroKey(privateKey)
+
+	violation, err := b.checkTransaction(ctx, storage, account, tx, chainId)
+	if err != nil {
+		return err
+	}
+	var signedTx *types.Transaction
+	if violation == nil {
+		if signedTx, violation, err = b.finalizeTransaction(ctx, storage, account, privateKey, tx, chainId, request.RequestedBy); err != nil {
+			return err
+		}
+	}
+	if violation != nil {
+		request.Error = violation.Error()
+		return nil
+	}
+	request.ManagedNonce = account.ManagedNonces
+
+	signedTxBytes, err := signedTx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	request.SignedTransaction = hexutil.Encode(signedTxBytes)
+	request.TransactionHash = signedTx.Hash().Hex()
+	// give the requester time to collect the signed transaction
+	request.ExpiresAt = time.Now().UTC().Add(account.Policy.approvalTTL())
+	return nil
+}
+
+func (b *backend) deleteApproval(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	id := data.Get("id").(string)
+
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("approvals/%s", id)); err != nil {
+		b.Logger().Error("Failed to delete the approval request from storage", "id", id, "error", err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// expireApprovals removes the requests that expired, whether they were approved or not
+func (b *backend) expireApprovals(ctx context.Context, storage logical.Storage) error {
+	ids, err := storage.List(ctx, "approvals/")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, id := range ids {
+		if err := b.expireApproval(ctx, storage, id, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) expireApproval(ctx context.Context, storage logical.Storage, id string, now time.Time) error {
+	lock := locksutil.LockForKey(b.approvalLocks, id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// read under the lock, an approval may have signed the transaction since it was listed
+	request, err := b.retrieveApprovalRequest(ctx, storage, id)
+	if err != nil {
+		return err
+	}
+	if request == nil || now.Before(request.ExpiresAt) {
+		return nil
+	}
+	if err := storage.Delete(ctx, fmt.Sprintf("approvals/%s", id)); err != nil {
+		return err
+	}
+	b.Logger().Info("Removed expired approval request", "id", id, "status", request.status(now))
+	return nil
+}
+
+func (b *backend) retrieveApprovalRequest(ctx context.Context, storage logical.Storage, id string) (*ApprovalRequest, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("approvals/%s", id))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the approval request", "id", id, "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var request ApprovalRequest
+	if err := entry.DecodeJSON(&request); err != nil {
+		b.Logger().Error("Failed to decode the approval request", "id", id, "error", err)
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (b *backend) saveApprovalRequest(ctx context.Context, storage logical.Storage, request *ApprovalRequest) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("approvals/%s", request.ID), request)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the approval request", "id", request.ID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (b *backend) approvalData(request *ApprovalRequest, required int) map[string]interface{} {
+	data := map[string]interface{}{
+		"approval_id":        request.ID,
+		"account":            request.Account,
+		"status":             request.status(time.Now()),
+		"transaction":        request.Transaction,
+		"chain_id":           request.ChainID,
+		"requested_by":       request.RequestedBy,
+		"approvals":          request.Approvals,
+		"required_approvals": required,
+		"expires_at":         request.ExpiresAt.Format(time.RFC3339),
+	}
+	if request.ManagedNonce && request.SignedTransaction == "" {
+		data["nonce_pending"] = true
+	}
+	if request.SignedTransaction != "" {
+		data["signed_transaction"] = request.SignedTransaction
+		data["transaction_hash"] = request.TransactionHash
+	}
+	if request.Error != "" {
+		data["error_message"] = request.Error
+	}
+	return data
+}
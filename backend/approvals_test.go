@@ -0,0 +1,343 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requireApprovals(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) {
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = data
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func approveRequest(t *testing.T, b logical.Backend, storage logical.Storage, id, entityID string) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.UpdateOperation, "approvals/"+id)
+	req.Storage = storage
+	req.EntityID = entityID
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestApprovals(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 2,
+		"approvers":         "entity1,entity2,entity3",
+		"approvalTtl":       "1h",
+	})
+
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign")
+	req.Storage = storage
+	req.EntityID = "requester"
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"to":      allowedTo,
+		"value":   "1000",
+		"chainId": "12345",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Nil(resp.Data["signed_transaction"])
+	assert.Equal(ApprovalPending, resp.Data["status"])
+	assert.Equal("requester", resp.Data["requested_by"])
+	assert.Equal(2, resp.Data["required_approvals"])
+	id := resp.Data["approval_id"].(string)
+
+	req = logical.TestRequest(t, logical.ListOperation, "approvals/")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]string{id}, resp.Data["keys"])
+
+	resp, err = approveRequest(t, b, storage, id, "entity1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(ApprovalPending, resp.Data["status"])
+	assert.Equal([]string{"entity1"}, resp.Data["approvals"])
+
+	_, err = approveRequest(t, b, storage, id, "entity1")
+	assert.Equal("Approval request was already approved by entity1", err.Error())
+	_, err = approveRequest(t, b, storage, id, "requester")
+	assert.Equal("Approval requests cannot be approved by the requester", err.Error())
+	_, err = approveRequest(t, b, storage, id, "stranger")
+	assert.Equal(logical.ErrPermissionDenied, err)
+	_, err = approveRequest(t, b, storage, id, "")
+	assert.Equal("Approvals can only be given by callers with an identity entity", err.Error())
+
+	resp, err = approveRequest(t, b, storage, id, "entity2")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(ApprovalApproved, resp.Data["status"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "approvals/"+id)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(ApprovalApproved, resp.Data["status"])
+	assert.Equal([]string{"entity1", "entity2"}, resp.Data["approvals"])
+
+	signedTxBytes, _ := hexutil.Decode(resp.Data["signed_transaction"].(string))
+	tx := new(types.Transaction)
+	assert.Nil(tx.UnmarshalBinary(signedTxBytes))
+	assert.Equal(resp.Data["transaction_hash"], tx.Hash().Hex())
+	assert.Equal(big.NewInt(1000), tx.Value())
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(12345)), tx)
+	assert.Nil(err)
+	assert.Equal(testAddress, strings.ToLower(sender.Hex()))
+
+	// recorded in the history of the account on behalf of the requester
+	resp, err = readTestHistory(t, b, storage, nil)
+	assert.Nil(err)
+	entries := resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(1, len(entries))
+	assert.Equal(tx.Hash().Hex(), entries[0]["transaction_hash"])
+	assert.Equal("requester", entries[0]["requested_by"])
+
+	_, err = approveRequest(t, b, storage, id, "entity3")
+	assert.Equal("Approval request is approved", err.Error())
+
+	// removed once expired
+	request, _ := b.(*backend).retrieveApprovalRequest(context.Background(), storage, id)
+	request.ExpiresAt = time.Now().Add(-time.Minute)
+	b.(*backend).saveApprovalRequest(context.Background(), storage, request)
+	req = logical.TestRequest(t, logical.RollbackOperation, "")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	request, _ = b.(*backend).retrieveApprovalRequest(context.Background(), storage, id)
+	assert.Nil(request)
+}
+
+func TestApprovalsExpiredAndFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 1,
+		"spendLimit":        "1000",
+	})
+
+	resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "600", "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	first := resp.Data["approval_id"].(string)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "600", "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	second := resp.Data["approval_id"].(string)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1", "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	third := resp.Data["approval_id"].(string)
+
+	// the spend limit applies when the transaction is signed
+	resp, err = approveRequest(t, b, storage, first, "entity1")
+	assert.Nil(err)
+	assert.Equal(ApprovalApproved, resp.Data["status"])
+	resp, err = approveRequest(t, b, storage, second, "entity1")
+	assert.Nil(err)
+	assert.Equal(ApprovalFailed, resp.Data["status"])
+	assert.Equal("Policy violation [spend_limit]: value 600 exceeds the remaining allowance of 400 for the last 24h0m0s", resp.Data["error_message"])
+
+	request, _ := b.(*backend).retrieveApprovalRequest(context.Background(), storage, third)
+	request.ExpiresAt = time.Now().Add(-time.Minute)
+	b.(*backend).saveApprovalRequest(context.Background(), storage, request)
+	_, err = approveRequest(t, b, storage, third, "entity1")
+	assert.Equal("Approval request is expired", err.Error())
+
+	_, err = approveRequest(t, b, storage, "unknown", "entity1")
+	assert.Equal("Approval request does not exist", err.Error())
+
+	req := logical.TestRequest(t, logical.DeleteOperation, "approvals/"+third)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	req = logical.TestRequest(t, logical.ReadOperation, "approvals/"+third)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"requiredApprovals": 3,
+		"approvers":         "entity1,entity2",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("3 approvals are required but only 2 approvers are allowed", resp.Error().Error())
+}
+
+func TestApprovalsManagedNonces(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 1,
+	})
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"managedNonces": true,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the nonce is only allocated once the transaction is approved
+	resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(true, resp.Data["nonce_pending"])
+	assert.Nil(resp.Data["nonce"])
+	first := resp.Data["approval_id"].(string)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	second := resp.Data["approval_id"].(string)
+
+	// in the order the transactions are approved
+	resp, err = approveRequest(t, b, storage, second, "entity1")
+	assert.Nil(err)
+	assert.Equal(ApprovalApproved, resp.Data["status"])
+	assert.Nil(resp.Data["nonce_pending"])
+	tx, _ := signedSender(t, resp)
+	assert.Equal(uint64(0), tx.Nonce())
+	resp, err = approveRequest(t, b, storage, first, "entity1")
+	assert.Nil(err)
+	tx, _ = signedSender(t, resp)
+	assert.Equal(uint64(1), tx.Nonce())
+
+	// the placeholder nonce is never signed once the nonces are no longer managed
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	third := resp.Data["approval_id"].(string)
+	req.Data = map[string]interface{}{
+		"managedNonces": false,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = approveRequest(t, b, storage, third, "entity1")
+	assert.Equal("The nonces of the account are no longer managed by the plugin, the transaction must be signed again", err.Error())
+	resp, err = readTestHistory(t, b, storage, nil)
+	assert.Nil(err)
+	assert.Equal(2, len(resp.Data["entries"].([]map[string]interface{})))
+}
+
+func TestApprovalsLockCollision(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 1,
+	})
+
+	resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1", "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// this ID hashes to the same storage lock as the head of the signing log, which is taken
+	// when the transaction is signed
+	collidingID := "61d30c8e-b516-45c6-832e-a25dfd7fface"
+	assert.Equal(locksutil.LockForKey(b.(*backend).locks, logHeadPath), locksutil.LockForKey(b.(*backend).locks, "approvals/"+collidingID))
+	request, _ := b.(*backend).retrieveApprovalRequest(context.Background(), storage, resp.Data["approval_id"].(string))
+	assert.Nil(storage.Delete(context.Background(), "approvals/"+request.ID))
+	request.ID = collidingID
+	assert.Nil(b.(*backend).saveApprovalRequest(context.Background(), storage, request))
+
+	done := make(chan *logical.Response)
+	go func() {
+		resp, err := approveRequest(t, b, storage, collidingID, "entity1")
+		assert.Nil(err)
+		done <- resp
+	}()
+	select {
+	case resp = <-done:
+		assert.Equal(ApprovalApproved, resp.Data["status"])
+	case <-time.After(10 * time.Second):
+		t.Fatal("approval did not complete")
+	}
+}
+
+func TestApprovalsExpiryWaitsForApproval(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 1,
+	})
+	resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	id := resp.Data["approval_id"].(string)
+	request, _ := b.(*backend).retrieveApprovalRequest(context.Background(), storage, id)
+	request.ExpiresAt = time.Now().Add(-time.Minute)
+	b.(*backend).saveApprovalRequest(context.Background(), storage, request)
+
+	// the expiry waits for the approval in progress, and sees the request it saved
+	lock := locksutil.LockForKey(b.(*backend).approvalLocks, id)
+	lock.Lock()
+	done := make(chan error)
+	go func() {
+		done <- b.(*backend).expireApprovals(context.Background(), storage)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	request.SignedTransaction = "0x00"
+	request.ExpiresAt = time.Now().Add(time.Hour)
+	b.(*backend).saveApprovalRequest(context.Background(), storage, request)
+	lock.Unlock()
+	assert.Nil(<-done)
+	request, _ = b.(*backend).retrieveApprovalRequest(context.Background(), storage, id)
+	assert.NotNil(request)
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -37,6 +39,10 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 // Backend returns the backend
 func Backend() (*backend, error) {
 	var b backend
+	b.locks = locksutil.CreateLocks()
+	b.accountLocks = locksutil.CreateLocks()
+	b.nameLocks = locksutil.CreateLocks()
+	b.approvalLocks = locksutil.CreateLocks()
 	b.Backend = &framework.Backend{
 		Help: "",
 		Paths: framework.PathAppend(
@@ -45,10 +51,15 @@ func Backend() (*backend, error) {
 		PathsSpecial: &logical.Paths{
 			SealWrapStorage: []string{
 				"accounts/",
+				"wallets/",
+				"wrapping_key",
+				"deleted/",
+				"attestation_key",
 			},
 		},
-		Secrets:     []*framework.Secret{},
-		BackendType: logical.TypeLogical,
+		Secrets:      []*framework.Secret{},
+		BackendType:  logical.TypeLogical,
+		PeriodicFunc: b.periodicFunc,
 	}
 	return &b, nil
 }
@@ -56,6 +67,41 @@ func Backend() (*backend, error) {
 // backend implements the Backend for this plugin
 type backend struct {
 	*framework.Backend
+
+	// locks serialize the read-modify-write updates of storage entries
+	locks []*locksutil.LockEntry
+	// accountLocks serialize the read-modify-write updates of the account records, they're
+	// taken while holding some of locks, and before nameLocks
+	accountLocks []*locksutil.LockEntry
+	// nameLocks serialize the claims of the account names, they are kept apart from locks
+	// as they're taken while holding some of those
+	nameLocks []*locksutil.LockEntry
+	// approvalLocks serialize the updates of the approval requests, they are kept apart from
+	// locks as the final approval signs the transaction, which takes some of those
+	approvalLocks []*locksutil.LockEntry
+}
+
+// periodicFunc is invoked by Vault about once a minute, to clean up the entries that expired.
+// Every task runs even if an earlier one failed, their errors are combined
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var result *multierror.Error
+	if err := b.expireApprovals(ctx, req.Storage); err != nil {
+		b.Logger().Error("Failed to remove the expired approval requests", "error", err)
+		result = multierror.Append(result, err)
+	}
+	if err := b.purgeDeletedAccounts(ctx, req.Storage); err != nil {
+		b.Logger().Error("Failed to purge the deleted accounts past retention", "error", err)
+		result = multierror.Append(result, err)
+	}
+	if err := b.expireHistory(ctx, req.Storage); err != nil {
+		b.Logger().Error("Failed to remove the signing history past retention", "error", err)
+		result = multierror.Append(result, err)
+	}
+	if err := b.attestLog(ctx, req.Storage); err != nil {
+		b.Logger().Error("Failed to attest the signing log", "error", err)
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
 }
 
 func (b *backend) pathExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
@@ -0,0 +1,225 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Config holds the mount-wide settings, which apply to all the accounts on top of their own policy
+type Config struct {
+	// decimal chain IDs transactions can be signed for, empty means any chain
+	ChainIDs []string `json:"chain_ids,omitempty"`
+	// refuse to sign legacy transactions without a chain ID (pre-EIP-155)
+	ForbidUnprotected bool `json:"forbid_unprotected"`
+	// used for the fields omitted from transaction requests, a default chain ID of
+	// 0 signs legacy transactions without a chain ID
+	DefaultChainID  string `json:"default_chain_id"`
+	DefaultGasLimit uint64 `json:"default_gas_limit"`
+	DefaultGasPrice string `json:"default_gas_price"`
+	// whether private keys can be exported from, and imported into, the mount
+	AllowExport bool `json:"allow_export"`
+	AllowImport bool `json:"allow_import"`
+	// maximum size in bytes of the transaction data and messages to sign, 0 means no limit
+	MaxDataSize int `json:"max_data_size"`
+	// seconds deleted accounts can be restored for, before they're purged
+	DeletedRetention int64 `json:"deleted_retention"`
+	// seconds the signing history of the accounts is kept for, 0 keeps it forever
+	HistoryRetention int64 `json:"history_retention"`
+	// seconds between the signatures of the signing log head, 0 disables them
+	AttestationInterval int64 `json:"attestation_interval"`
+}
+
+// defaultConfig returns the settings of a mount that was never configured
+func defaultConfig() *Config {
+	return &Config{
+		DefaultChainID:   "0",
+		DefaultGasLimit:  90000,
+		DefaultGasPrice:  "0",
+		AllowExport:      true,
+		AllowImport:      true,
+		DeletedRetention: int64(DefaultDeletedRetention.Seconds()),
+	}
+}
+
+// Check makes sure the transaction can be signed for the chain under the mount configuration
+func (c *Config) Check(chainId *big.Int) *PolicyViolation {
+	return checkChainID(chainId, c.ChainIDs, c.ForbidUnprotected, "mount configuration")
+}
+
+// checkDataSize makes sure the data to sign is not larger than the maximum of the mount
+func (c *Config) checkDataSize(field string, size int) error {
+	if c.MaxDataSize > 0 && size > c.MaxDataSize {
+		return fmt.Errorf("'%s' is %d bytes, larger than the maximum of %d bytes", field, size, c.MaxDataSize)
+	}
+	return nil
+}
+
+// checkExportAllowed returns an error if the private key of the account cannot be exported
+func (b *backend) checkExportAllowed(ctx context.Context, storage logical.Storage, account *Account) error {
+	if !account.isExportable() {
+		return fmt.Errorf("Account %s is not exportable", account.Address)
+	}
+	config, err := b.retrieveConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if !config.AllowExport {
+		return fmt.Errorf("Exporting private keys is disabled by the mount configuration")
+	}
+	return nil
+}
+
+func (b *backend) readConfig(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: configData(config),
+	}, nil
+}
+
+func (b *backend) updateConfig(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	lock := locksutil.LockForKey(b.locks, "config")
+	lock.Lock()
+	defer lock.Unlock()
+
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// fields that are not in the request keep their current value
+	if chainIds, ok := data.GetOk("chainIds"); ok {
+		if config.ChainIDs, err = chainIDsFromInput(chainIds.([]string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+	if forbidUnprotected, ok := data.GetOk("forbidUnprotected"); ok {
+		config.ForbidUnprotected = forbidUnprotected.(bool)
+	}
+	if defaultChainId, ok := data.GetOk("defaultChainId"); ok {
+		chainId, valid := math.ParseBig256(defaultChainId.(string))
+		if !valid || chainId.Sign() < 0 {
+			return logical.ErrorResponse("Invalid 'defaultChainId' value"), logical.ErrInvalidRequest
+		}
+		config.DefaultChainID = chainId.String()
+	}
+	if defaultGasLimit, ok := data.GetOk("defaultGasLimit"); ok {
+		gasLimit, valid := math.ParseUint64(defaultGasLimit.(string))
+		if !valid {
+			return logical.ErrorResponse("Invalid 'defaultGasLimit' value"), logical.ErrInvalidRequest
+		}
+		config.DefaultGasLimit = gasLimit
+	}
+	if defaultGasPrice, ok := data.GetOk("defaultGasPrice"); ok {
+		gasPrice, valid := math.ParseBig256(defaultGasPrice.(string))
+		if !valid || gasPrice.Sign() < 0 {
+			return logical.ErrorResponse("Invalid 'defaultGasPrice' value"), logical.ErrInvalidRequest
+		}
+		config.DefaultGasPrice = gasPrice.String()
+	}
+	if allowExport, ok := data.GetOk("allowExport"); ok {
+		config.AllowExport = allowExport.(bool)
+	}
+	if allowImport, ok := data.GetOk("allowImport"); ok {
+		config.AllowImport = allowImport.(bool)
+	}
+	if maxDataSize, ok := data.GetOk("maxDataSize"); ok {
+		if maxDataSize.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'maxDataSize' value"), logical.ErrInvalidRequest
+		}
+		config.MaxDataSize = maxDataSize.(int)
+	}
+	if deletedRetention, ok := data.GetOk("deletedRetention"); ok {
+		if deletedRetention.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'deletedRetention' value, it can't be negative"), logical.ErrInvalidRequest
+		}
+		config.DeletedRetention = int64(deletedRetention.(int))
+	}
+	if historyRetention, ok := data.GetOk("historyRetention"); ok {
+		if historyRetention.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'historyRetention' value, it can't be negative"), logical.ErrInvalidRequest
+		}
+		config.HistoryRetention = int64(historyRetention.(int))
+	}
+	if attestationInterval, ok := data.GetOk("attestationInterval"); ok {
+		if attestationInterval.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'attestationInterval' value, it can't be negative"), logical.ErrInvalidRequest
+		}
+		config.AttestationInterval = int64(attestationInterval.(int))
+	}
+
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the configuration to storage", "error", err)
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: configData(config),
+	}, nil
+}
+
+// retrieveConfig returns the mount configuration, or the defaults if it was never set
+func (b *backend) retrieveConfig(ctx context.Context, storage logical.Storage) (*Config, error) {
+	entry, err := storage.Get(ctx, "config")
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the configuration", "error", err)
+		return nil, err
+	}
+	// settings added after the configuration was saved keep their default
+	config := defaultConfig()
+	if entry == nil {
+		return config, nil
+	}
+	if err := entry.DecodeJSON(config); err != nil {
+		b.Logger().Error("Failed to decode the configuration", "error", err)
+		return nil, err
+	}
+	return config, nil
+}
+
+func configData(config *Config) map[string]interface{} {
+	chainIds := config.ChainIDs
+	if chainIds == nil {
+		chainIds = []string{}
+	}
+	return map[string]interface{}{
+		"chain_ids":            chainIds,
+		"forbid_unprotected":   config.ForbidUnprotected,
+		"default_chain_id":     config.DefaultChainID,
+		"default_gas_limit":    config.DefaultGasLimit,
+		"default_gas_price":    config.DefaultGasPrice,
+		"allow_export":         config.AllowExport,
+		"allow_import":         config.AllowImport,
+		"max_data_size":        config.MaxDataSize,
+		"deleted_retention":    config.DeletedRetention,
+		"history_retention":    config.HistoryRetention,
+		"attestation_interval": config.AttestationInterval,
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigChainIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "config")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]string{}, resp.Data["chain_ids"])
+	assert.Equal(false, resp.Data["forbid_unprotected"])
+
+	// unprotected signatures are allowed by default
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Nil(err)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"forbidUnprotected": true,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(true, resp.Data["forbid_unprotected"])
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [forbid_unprotected]: transactions without a chain ID are forbidden by the mount configuration", resp.Data["error"])
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "12345"})
+	assert.Nil(err)
+
+	req.Data = map[string]interface{}{
+		"chainIds": "1,0x3039",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]string{"1", "12345"}, resp.Data["chain_ids"])
+	assert.Equal(true, resp.Data["forbid_unprotected"])
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "12345"})
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "maxFeePerGas": "1", "chainId": "1"})
+	assert.Nil(err)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "5"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: chain ID 5 is not allowed by the mount configuration", resp.Data["error"])
+
+	// pinned chain IDs alone are enough to refuse unprotected signatures
+	req.Data = map[string]interface{}{
+		"forbidUnprotected": false,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: a chain ID is required by the mount configuration", resp.Data["error"])
+
+	req.Data = map[string]interface{}{
+		"chainIds": "mainnet",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Invalid chain ID mainnet", resp.Error().Error())
+}
+
+func TestConfigDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "config")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0", resp.Data["default_chain_id"])
+	assert.Equal(uint64(90000), resp.Data["default_gas_limit"])
+	assert.Equal("0", resp.Data["default_gas_price"])
+	assert.Equal(true, resp.Data["allow_export"])
+	assert.Equal(true, resp.Data["allow_import"])
+	assert.Equal(0, resp.Data["max_data_size"])
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Nil(err)
+	tx := new(types.Transaction)
+	signedTxBytes, _ := hexutil.Decode(resp.Data["signed_transaction"].(string))
+	assert.Nil(tx.UnmarshalBinary(signedTxBytes))
+	assert.Equal(uint64(90000), tx.Gas())
+	assert.Equal(big.NewInt(0), tx.GasPrice())
+	assert.False(tx.Protected())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"defaultChainId":  "0x3039",
+		"defaultGasLimit": "21000",
+		"defaultGasPrice": "1000000000",
+		"maxDataSize":     4,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("12345", resp.Data["default_chain_id"])
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0xdeadbeef", "to": allowedTo})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signedTxBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	assert.Nil(tx.UnmarshalBinary(signedTxBytes))
+	assert.Equal(uint64(21000), tx.Gas())
+	assert.Equal(big.NewInt(1000000000), tx.GasPrice())
+	assert.Equal(big.NewInt(12345), tx.ChainId())
+
+	// the default chain ID applies to EIP-1559 transactions too
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "maxFeePerGas": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signedTxBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	assert.Nil(tx.UnmarshalBinary(signedTxBytes))
+	assert.Equal(big.NewInt(12345), tx.ChainId())
+
+	// explicit values win over the defaults
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "gas": "50000", "gasPrice": "0", "chainId": "1"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signedTxBytes, _ = hexutil.Decode(resp.Data["signed_transaction"].(string))
+	assert.Nil(tx.UnmarshalBinary(signedTxBytes))
+	assert.Equal(uint64(50000), tx.Gas())
+	assert.Equal(big.NewInt(0), tx.GasPrice())
+	assert.Equal(big.NewInt(1), tx.ChainId())
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0xdeadbeef00", "to": allowedTo})
+	assert.Equal("'data' is 5 bytes, larger than the maximum of 4 bytes", err.Error())
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'message' is 5 bytes, larger than the maximum of 4 bytes", err.Error())
+
+	// the typed data is limited by the size of its JSON encoding
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-typed-data")
+	req.Storage = storage
+	req.Data = typedDataRequest(t, mailTypedData)
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Regexp("^'typedData' is [0-9]+ bytes, larger than the maximum of 4 bytes$", err.Error())
+
+	// and so are the inputs of the signature verification
+	req = logical.TestRequest(t, logical.UpdateOperation, "verify")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"typedData": typedDataRequest(t, mailTypedData),
+		"signature": "0x00",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Regexp("^'typedData' is [0-9]+ bytes, larger than the maximum of 4 bytes$", err.Error())
+	req.Data = map[string]interface{}{
+		"message":   "hello",
+		"signature": "0x00",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'message' is 5 bytes, larger than the maximum of 4 bytes", err.Error())
+	req.Data = map[string]interface{}{
+		"transaction": resp.Data["signed_transaction"],
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Regexp("^'transaction' is [0-9]+ bytes, larger than the maximum of 4 bytes$", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"defaultGasLimit": "lots",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Invalid 'defaultGasLimit' value", resp.Error().Error())
+}
+
+func TestConfigImportExport(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowExport": false,
+		"allowImport": false,
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Exporting private keys is disabled by the mount configuration", err.Error())
+
+	req = logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"password": "secret",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Exporting private keys is disabled by the mount configuration", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": "8d2e1e3e4b0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Importing private keys is disabled by the mount configuration", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":     "imported",
+		"mnemonic": testMnemonic,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Importing mnemonics is disabled by the mount configuration", err.Error())
+
+	// generated keys and wallets are still allowed
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name": "generated",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+}
+
+func TestConfigInvalidInput(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// all the invalid values are rejected as bad requests
+	invalid := map[string][]interface{}{
+		"chainIds":            {"mainnet", "Invalid chain ID mainnet"},
+		"defaultChainId":      {"-1", "Invalid 'defaultChainId' value"},
+		"defaultGasLimit":     {"lots", "Invalid 'defaultGasLimit' value"},
+		"defaultGasPrice":     {"0xzz", "Invalid 'defaultGasPrice' value"},
+		"maxDataSize":         {-1, "Invalid 'maxDataSize' value"},
+		"deletedRetention":    {"-1h", "Invalid 'deletedRetention' value, it can't be negative"},
+		"historyRetention":    {"-1h", "Invalid 'historyRetention' value, it can't be negative"},
+		"attestationInterval": {"-1h", "Invalid 'attestationInterval' value, it can't be negative"},
+	}
+	for field, test := range invalid {
+		req := logical.TestRequest(t, logical.UpdateOperation, "config")
+		req.Storage = storage
+		req.Data = map[string]interface{}{
+			field: test[0],
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		assert.Equal(logical.ErrInvalidRequest, err, field)
+		assert.True(resp.IsError(), field)
+		assert.Equal(test[1], resp.Error().Error())
+		status, _ := logical.RespondErrorCommon(req, resp, err)
+		assert.Equal(400, status, field)
+	}
+
+	req := logical.TestRequest(t, logical.ReadOperation, "config")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(int64(DefaultDeletedRetention.Seconds()), resp.Data["deleted_retention"])
+	assert.Equal(int64(0), resp.Data["history_retention"])
+	assert.Equal(int64(0), resp.Data["attestation_interval"])
+}
+
+func TestConfigConcurrentUpdates(t *testing.T) {
+	assert := assert.New(t)
+
+	b, inmem := getBackend(t)
+	storage := &slowStorage{inmem}
+
+	// updates of different fields at the same time all apply
+	var wg sync.WaitGroup
+	for _, data := range []map[string]interface{}{
+		{"defaultChainId": "12345"},
+		{"defaultGasLimit": "21000"},
+		{"maxDataSize": 1024},
+		{"allowImport": false},
+	} {
+		wg.Add(1)
+		go func(data map[string]interface{}) {
+			defer wg.Done()
+			req := logical.TestRequest(t, logical.UpdateOperation, "config")
+			req.Storage = storage
+			req.Data = data
+			_, err := b.HandleRequest(context.Background(), req)
+			assert.Nil(err)
+		}(data)
+	}
+	wg.Wait()
+
+	req := logical.TestRequest(t, logical.ReadOperation, "config")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal("12345", resp.Data["default_chain_id"])
+	assert.Equal(uint64(21000), resp.Data["default_gas_limit"])
+	assert.Equal(1024, resp.Data["max_data_size"])
+	assert.Equal(false, resp.Data["allow_import"])
+}
@@ -0,0 +1,292 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+// ContractPolicy restricts the calls an account can make to a contract to a set of methods
+// of its ABI, optionally constraining the values of their arguments
+type ContractPolicy struct {
+	// JSON ABI fragment, which must define all the allowed methods
+	ABI string `json:"abi"`
+	// keyed by method signature, such as "transfer(address,uint256)"
+	Methods map[string]*MethodPolicy `json:"methods"`
+
+	parsed *abi.ABI
+}
+
+// contractABI returns the parsed ABI of the contract, only parsing it the first time it's used
+// by the policy decoded from storage
+func (c *ContractPolicy) contractABI() (*abi.ABI, error) {
+	if c.parsed != nil {
+		return c.parsed, nil
+	}
+	contractABI, err := abi.JSON(strings.NewReader(c.ABI))
+	if err != nil {
+		return nil, err
+	}
+	c.parsed = &contractABI
+	return c.parsed, nil
+}
+
+// argumentKey returns the key of the constraints on a method argument: its name, or its
+// index for unnamed arguments
+func argumentKey(method *abi.Method, i int) string {
+	if name := method.Inputs[i].Name; name != "" {
+		return name
+	}
+	return strconv.Itoa(i)
+}
+
+// MethodPolicy holds the constraints on the arguments of an allowed method, keyed by argument
+// name, or by index for unnamed arguments
+type MethodPolicy struct {
+	Args map[string]*ArgConstraint `json:"args,omitempty"`
+}
+
+// ArgConstraint restricts the value of a method argument to a list of values, or to a
+// range for numeric arguments
+type ArgConstraint struct {
+	In  []string `json:"in,omitempty"`
+	Min string   `json:"min,omitempty"`
+	Max string   `json:"max,omitempty"`
+}
+
+// checkCall decodes the call data against the ABI of the contract, and returns the violation
+// if the method is not allowed or one of its arguments breaks a constraint
+func (c *ContractPolicy) checkCall(to string, data []byte) *PolicyViolation {
+	if len(data) < 4 {
+		return &PolicyViolation{RuleContractMethods, fmt.Sprintf("calls to %s must invoke one of the allowed methods", to)}
+	}
+	contractABI, err := c.contractABI()
+	if err != nil {
+		return &PolicyViolation{RuleContractMethods, fmt.Sprintf("the ABI of contract %s cannot be parsed", to)}
+	}
+	method, err := contractABI.MethodById(data[:4])
+	if err != nil {
+		return &PolicyViolation{RuleContractMethods, fmt.Sprintf("method %s is not allowed on %s", hexutil.Encode(data[:4]), to)}
+	}
+	methodPolicy, ok := c.Methods[method.Sig]
+	if !ok {
+		return &PolicyViolation{RuleContractMethods, fmt.Sprintf("method %s is not allowed on %s", method.Sig, to)}
+	}
+	if len(methodPolicy.Args) == 0 {
+		return nil
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return &PolicyViolation{RuleContractArguments, fmt.Sprintf("the arguments of %s cannot be decoded: %v", method.Sig, err)}
+	}
+	for i, input := range method.Inputs {
+		key := argumentKey(method, i)
+		constraint, ok := methodPolicy.Args[key]
+		if !ok {
+			continue
+		}
+		if !constraint.allows(input.Type, values[i]) {
+			return &PolicyViolation{RuleContractArguments, fmt.Sprintf("argument '%s' of %s is not allowed: %s", key, method.Sig, argString(values[i]))}
+		}
+	}
+	return nil
+}
+
+func (a *ArgConstraint) allows(typ abi.Type, value interface{}) bool {
+	if len(a.In) > 0 && !containsString(a.In, argString(value)) {
+		return false
+	}
+	if a.Min == "" && a.Max == "" {
+		return true
+	}
+	number, ok := new(big.Int).SetString(argString(value), 10)
+	if !ok {
+		return false
+	}
+	if a.Min != "" {
+		min, _ := new(big.Int).SetString(a.Min, 10)
+		if number.Cmp(min) < 0 {
+			return false
+		}
+	}
+	return !exceeds(number, a.Max)
+}
+
+// argString returns the canonical string form of a decoded argument, which is what the
+// "in" constraints are compared against
+func argString(value interface{}) string {
+	switch v := value.(type) {
+	case common.Address:
+		return strings.ToLower(v.Hex())
+	case []byte:
+		return hexutil.Encode(v)
+	case *big.Int:
+		return v.String()
+	}
+	// fixed size bytes are decoded to arrays of the matching size
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return hexutil.Encode(b)
+	}
+	return fmt.Sprint(value)
+}
+
+// contractsFromInput validates and normalizes the "contracts" field of a policy, which is
+// keyed by contract address. Methods can be given by name, signature or 4 byte selector,
+// and are stored by signature
+func contractsFromInput(raw map[string]interface{}) (map[string]*ContractPolicy, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	contracts := map[string]*ContractPolicy{}
+	for address, rawContract := range raw {
+		if !addressRegexp.MatchString(address) {
+			return nil, fmt.Errorf("Invalid contract address %s in 'contracts'", address)
+		}
+		address = "0x" + strings.TrimPrefix(strings.ToLower(address), "0x")
+
+		var input struct {
+			ABI     interface{}                          `json:"abi"`
+			Methods map[string]map[string]*ArgConstraint `json:"methods"`
+		}
+		encoded, _ := json.Marshal(rawContract)
+		if err := json.Unmarshal(encoded, &input); err != nil {
+			return nil, fmt.Errorf("Invalid policy for contract %s: %v", address, err)
+		}
+		// the ABI can be passed as a JSON array or as a string
+		abiJSON, ok := input.ABI.(string)
+		if !ok {
+			encodedABI, _ := json.Marshal(input.ABI)
+			abiJSON = string(encodedABI)
+		}
+		contractABI, err := abi.JSON(strings.NewReader(abiJSON))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ABI for contract %s: %v", address, err)
+		}
+		if len(input.Methods) == 0 {
+			return nil, fmt.Errorf("At least one method must be allowed for contract %s", address)
+		}
+
+		contract := &ContractPolicy{
+			ABI:     abiJSON,
+			Methods: map[string]*MethodPolicy{},
+			parsed:  &contractABI,
+		}
+		for name, args := range input.Methods {
+			method := findMethod(&contractABI, name)
+			if method == nil {
+				return nil, fmt.Errorf("Method %s is not defined in the ABI of contract %s", name, address)
+			}
+			methodPolicy := &MethodPolicy{}
+			for argName, constraint := range args {
+				if constraint == nil {
+					continue
+				}
+				if err := constraint.normalize(method, argName); err != nil {
+					return nil, err
+				}
+				if methodPolicy.Args == nil {
+					methodPolicy.Args = map[string]*ArgConstraint{}
+				}
+				methodPolicy.Args[argName] = constraint
+			}
+			contract.Methods[method.Sig] = methodPolicy
+		}
+		contracts[address] = contract
+	}
+	return contracts, nil
+}
+
+// findMethod looks up a method of the ABI by name, signature or selector
+func findMethod(contractABI *abi.ABI, name string) *abi.Method {
+	if selector, err := hexutil.Decode(name); err == nil && len(selector) == 4 {
+		method, err := contractABI.MethodById(selector)
+		if err != nil {
+			return nil
+		}
+		return method
+	}
+	for _, method := range contractABI.Methods {
+		if method.Sig == name || method.Name == name {
+			m := method
+			return &m
+		}
+	}
+	return nil
+}
+
+// normalize makes sure the constraint applies to an argument of the method, and converts
+// its values to the canonical form of the argument type
+func (a *ArgConstraint) normalize(method *abi.Method, argName string) error {
+	var input *abi.Argument
+	unnamed := false
+	for i := range method.Inputs {
+		if argumentKey(method, i) == argName {
+			input = &method.Inputs[i]
+		}
+		unnamed = unnamed || method.Inputs[i].Name == ""
+	}
+	if input == nil && unnamed {
+		return fmt.Errorf("Method %s has no argument '%s', its unnamed arguments are constrained by index", method.Sig, argName)
+	}
+	if input == nil {
+		return fmt.Errorf("Method %s has no argument '%s'", method.Sig, argName)
+	}
+
+	numeric := input.Type.T == abi.IntTy || input.Type.T == abi.UintTy
+	for i, value := range a.In {
+		switch input.Type.T {
+		case abi.AddressTy:
+			if !addressRegexp.MatchString(value) {
+				return fmt.Errorf("Invalid address %s for argument '%s' of %s", value, argName, method.Sig)
+			}
+			a.In[i] = "0x" + strings.TrimPrefix(strings.ToLower(value), "0x")
+		case abi.IntTy, abi.UintTy:
+			number, ok := math.ParseBig256(value)
+			if !ok {
+				return fmt.Errorf("Invalid number %s for argument '%s' of %s", value, argName, method.Sig)
+			}
+			a.In[i] = number.String()
+		case abi.BoolTy, abi.StringTy:
+		case abi.BytesTy, abi.FixedBytesTy:
+			a.In[i] = strings.ToLower(value)
+		default:
+			return fmt.Errorf("Argument '%s' of %s cannot be constrained", argName, method.Sig)
+		}
+	}
+	if (a.Min != "" || a.Max != "") && !numeric {
+		return fmt.Errorf("Argument '%s' of %s is not a number, 'min' and 'max' cannot be used", argName, method.Sig)
+	}
+	var err error
+	if a.Min, err = parseLimit("min", a.Min); err != nil {
+		return err
+	}
+	if a.Max, err = parseLimit("max", a.Max); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const erc20ABI = `[
+	{"type":"function","name":"transfer","stateMutability":"nonpayable","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"approve","stateMutability":"nonpayable","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"transferFrom","stateMutability":"nonpayable","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+const tokenAddress = "0x6b175474e89094c44da98b954eedeac495271d0f"
+const spenderAddress = "0x1111111254fb6c44bac0bed2854e76f90643097d"
+
+func erc20Call(t *testing.T, method string, args ...interface{}) string {
+	parsed, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	data, err := parsed.Pack(method, args...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return hexutil.Encode(data)
+}
+
+func TestContractPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	var contracts map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"`+strings.ToUpper(tokenAddress[2:])+`": {
+			"abi": `+erc20ABI+`,
+			"methods": {
+				"transfer": {"amount": {"max": "1000"}},
+				"0x095ea7b3": {"spender": {"in": ["`+strings.ToUpper(spenderAddress[2:])+`"]}, "amount": {"min": "1", "max": "0x3e8"}}
+			}
+		}
+	}`), &contracts)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowedTo": allowedTo,
+		"contracts": contracts,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	stored := resp.Data["contracts"].(map[string]*ContractPolicy)[tokenAddress]
+	assert.Equal(2, len(stored.Methods))
+	assert.Equal("1000", stored.Methods["transfer(address,uint256)"].Args["amount"].Max)
+	assert.Equal([]string{spenderAddress}, stored.Methods["approve(address,uint256)"].Args["spender"].In)
+	assert.Equal("1000", stored.Methods["approve(address,uint256)"].Args["amount"].Max)
+
+	recipient := common.HexToAddress(allowedTo)
+	spender := common.HexToAddress(spenderAddress)
+
+	// allowed calls
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": erc20Call(t, "transfer", recipient, big.NewInt(1000)), "to": tokenAddress, "chainId": "1"})
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": erc20Call(t, "approve", spender, big.NewInt(500)), "to": tokenAddress, "chainId": "1"})
+	assert.Nil(err)
+	// destinations without a contract policy are not affected
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0xdeadbeef", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+
+	violations := []struct {
+		data    string
+		message string
+	}{
+		{erc20Call(t, "transferFrom", recipient, recipient, big.NewInt(1)), "Policy violation [contract_methods]: method transferFrom(address,address,uint256) is not allowed on " + tokenAddress},
+		{"0xdeadbeef", "Policy violation [contract_methods]: method 0xdeadbeef is not allowed on " + tokenAddress},
+		{"0x", "Policy violation [contract_methods]: calls to " + tokenAddress + " must invoke one of the allowed methods"},
+		{erc20Call(t, "transfer", recipient, big.NewInt(1001)), "Policy violation [contract_arguments]: argument 'amount' of transfer(address,uint256) is not allowed: 1001"},
+		{erc20Call(t, "approve", recipient, big.NewInt(500)), "Policy violation [contract_arguments]: argument 'spender' of approve(address,uint256) is not allowed: " + allowedTo},
+		{erc20Call(t, "approve", spender, big.NewInt(0)), "Policy violation [contract_arguments]: argument 'amount' of approve(address,uint256) is not allowed: 0"},
+		{"0xa9059cbb00", "Policy violation [contract_arguments]: the arguments of transfer(address,uint256) cannot be decoded: abi: cannot marshal in to go type: length insufficient 1 require 32"},
+	}
+	for _, v := range violations {
+		resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": v.data, "to": tokenAddress, "chainId": "1"})
+		assert.Equal(logical.ErrPermissionDenied, err)
+		assert.Equal(v.message, resp.Data["error"])
+	}
+
+	// other destinations are still restricted by allowedTo
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": spenderAddress, "chainId": "1"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [allowed_to]: destination "+spenderAddress+" is not allowed", resp.Data["error"])
+}
+
+func TestContractPolicyFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+
+	failures := []struct {
+		contracts string
+		message   string
+	}{
+		{`{"0x1234": {}}`, "Invalid contract address 0x1234 in 'contracts'"},
+		{`{"` + tokenAddress + `": {"abi": "not an abi", "methods": {"transfer": {}}}}`, "Invalid ABI for contract " + tokenAddress + ": invalid character 'o' in literal null (expecting 'u')"},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `}}`, "At least one method must be allowed for contract " + tokenAddress},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `, "methods": {"mint": {}}}}`, "Method mint is not defined in the ABI of contract " + tokenAddress},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `, "methods": {"transfer": {"value": {"max": "1"}}}}}`, "Method transfer(address,uint256) has no argument 'value'"},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `, "methods": {"transfer": {"to": {"max": "1"}}}}}`, "Argument 'to' of transfer(address,uint256) is not a number, 'min' and 'max' cannot be used"},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `, "methods": {"transfer": {"to": {"in": ["0x1234"]}}}}}`, "Invalid address 0x1234 for argument 'to' of transfer(address,uint256)"},
+		{`{"` + tokenAddress + `": {"abi": ` + erc20ABI + `, "methods": {"transfer": {"amount": {"in": ["lots"]}}}}}`, "Invalid number lots for argument 'amount' of transfer(address,uint256)"},
+	}
+	for _, f := range failures {
+		var contracts map[string]interface{}
+		if err := json.Unmarshal([]byte(f.contracts), &contracts); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		req.Data = map[string]interface{}{
+			"contracts": contracts,
+		}
+		resp, err := b.HandleRequest(context.Background(), req)
+		assert.Equal(logical.ErrInvalidRequest, err)
+		assert.Equal(f.message, resp.Error().Error())
+	}
+}
+
+func TestContractPolicyUnnamedArguments(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	unnamedABI := `[{"type":"function","name":"transfer","inputs":[{"name":"","type":"address"},{"name":"","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}]`
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	var contracts map[string]interface{}
+	json.Unmarshal([]byte(`{"`+tokenAddress+`": {"abi": `+unnamedABI+`, "methods": {"transfer": {"amount": {"max": "10"}}}}}`), &contracts)
+	req.Data = map[string]interface{}{
+		"contracts": contracts,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Method transfer(address,uint256) has no argument 'amount', its unnamed arguments are constrained by index", resp.Error().Error())
+
+	// unnamed arguments are constrained by index
+	json.Unmarshal([]byte(`{"`+tokenAddress+`": {"abi": `+unnamedABI+`, "methods": {"transfer": {"1": {"max": "10"}}}}}`), &contracts)
+	req.Data = map[string]interface{}{
+		"contracts": contracts,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	recipient := common.HexToAddress(allowedTo)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": erc20Call(t, "transfer", recipient, big.NewInt(10)), "to": tokenAddress, "chainId": "1"})
+	assert.Nil(err)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": erc20Call(t, "transfer", recipient, big.NewInt(11)), "to": tokenAddress, "chainId": "1"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [contract_arguments]: argument '1' of transfer(address,uint256) is not allowed: 11", resp.Data["error"])
+}
+
+func TestContractABIParsedOnce(t *testing.T) {
+	assert := assert.New(t)
+
+	// the ABI is parsed the first time the decoded policy uses it, and isn't kept past it
+	policy := &ContractPolicy{ABI: erc20ABI}
+	parsed, err := policy.contractABI()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	again, err := policy.contractABI()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Same(parsed, again)
+	other, err := (&ContractPolicy{ABI: erc20ABI}).contractABI()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NotSame(parsed, other)
+
+	_, err = (&ContractPolicy{ABI: "not an abi"}).contractABI()
+	assert.NotNil(err)
+}
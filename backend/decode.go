@@ -0,0 +1,252 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// rawTransaction is a transaction decoded from its binary encoding, signed or not
+type rawTransaction struct {
+	// the unsigned transaction
+	tx *types.Transaction
+	// nil for the unsigned legacy transactions that don't carry one
+	chainId *big.Int
+	// the decoded transaction, if it was signed
+	signed *types.Transaction
+}
+
+// unsignedLegacyTx is the RLP encoding of a legacy transaction before EIP-155, without signature
+type unsignedLegacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       *common.Address `rlp:"nil"`
+	Value    *big.Int
+	Data     []byte
+}
+
+// unsignedAccessListTx is the RLP payload of an EIP-2930 transaction, without signature
+type unsignedAccessListTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasPrice   *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+}
+
+// unsignedDynamicFeeTx is the RLP payload of an EIP-1559 transaction, without signature
+type unsignedDynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+}
+
+func (b *backend) decodeTransaction(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx, signedTx *types.Transaction
+	var chainId *big.Int
+	if rawInput, ok := data.GetOk("transaction"); ok {
+		raw, err := decodeRawTransaction(rawInput.(string))
+		if err != nil {
+			return nil, err
+		}
+		tx, chainId, signedTx = raw.tx, raw.chainId, raw.signed
+		if chainId == nil {
+			chainId, _ = new(big.Int).SetString(config.DefaultChainID, 10)
+		}
+	} else {
+		input, err := b.transactionInputFromFields(data)
+		if err != nil {
+			return nil, err
+		}
+		if tx, chainId, err = input.transaction(config); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.checkDataSize("data", len(tx.Data())); err != nil {
+		return nil, err
+	}
+
+	result := transactionData(tx, chainId)
+	if signedTx != nil {
+		sender, err := types.Sender(transactionSigner(signedTx.Type(), chainId), signedTx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to recover the transaction sender: %v", err)
+		}
+		result["from"] = strings.ToLower(sender.Hex())
+		result["transaction_hash"] = signedTx.Hash().Hex()
+	}
+	return &logical.Response{
+		Data: result,
+	}, nil
+}
+
+// decodeRawTransaction decodes a legacy RLP or EIP-2718 typed transaction, either signed or
+// unsigned. Unsigned transactions can have zero signature values, like the ones encoded by
+// go-ethereum, or no signature values at all.
+func decodeRawTransaction(input string) (*rawTransaction, error) {
+	if !strings.HasPrefix(input, "0x") {
+		input = "0x" + input
+	}
+	txBytes, err := hexutil.Decode(input)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid hex value for the 'transaction' field: %v", err)
+	}
+	if len(txBytes) == 0 {
+		return nil, fmt.Errorf("'transaction' is empty")
+	}
+
+	decoded := new(types.Transaction)
+	if err := decoded.UnmarshalBinary(txBytes); err != nil {
+		tx, chainId, unsignedErr := decodeUnsignedTransaction(txBytes)
+		if unsignedErr != nil {
+			return nil, fmt.Errorf("Failed to decode the transaction: %v", err)
+		}
+		return &rawTransaction{tx: tx, chainId: chainId}, nil
+	}
+
+	raw := &rawTransaction{tx: withNonce(decoded, decoded.Nonce())}
+	v, r, s := decoded.RawSignatureValues()
+	if r.Sign() == 0 && s.Sign() == 0 {
+		// unsigned EIP-155 legacy transactions carry the chain ID in place of the 'v' value
+		if decoded.Type() == types.LegacyTxType {
+			if v.Sign() != 0 {
+				raw.chainId = v
+			}
+		} else {
+			raw.chainId = decoded.ChainId()
+		}
+		return raw, nil
+	}
+	raw.signed = decoded
+	raw.chainId = decoded.ChainId()
+	return raw, nil
+}
+
+// decodeUnsignedTransaction decodes the encodings of the transactions without signature values
+func decodeUnsignedTransaction(txBytes []byte) (*types.Transaction, *big.Int, error) {
+	switch {
+	case txBytes[0] == types.DynamicFeeTxType:
+		var inner unsignedDynamicFeeTx
+		if err := rlp.DecodeBytes(txBytes[1:], &inner); err != nil {
+			return nil, nil, err
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    inner.ChainID,
+			Nonce:      inner.Nonce,
+			GasTipCap:  inner.GasTipCap,
+			GasFeeCap:  inner.GasFeeCap,
+			Gas:        inner.Gas,
+			To:         inner.To,
+			Value:      inner.Value,
+			Data:       inner.Data,
+			AccessList: inner.AccessList,
+		}), inner.ChainID, nil
+	case txBytes[0] == types.AccessListTxType:
+		var inner unsignedAccessListTx
+		if err := rlp.DecodeBytes(txBytes[1:], &inner); err != nil {
+			return nil, nil, err
+		}
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    inner.ChainID,
+			Nonce:      inner.Nonce,
+			GasPrice:   inner.GasPrice,
+			Gas:        inner.Gas,
+			To:         inner.To,
+			Value:      inner.Value,
+			Data:       inner.Data,
+			AccessList: inner.AccessList,
+		}), inner.ChainID, nil
+	case txBytes[0] >= 0xc0:
+		var inner unsignedLegacyTx
+		if err := rlp.DecodeBytes(txBytes, &inner); err != nil {
+			return nil, nil, err
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    inner.Nonce,
+			GasPrice: inner.GasPrice,
+			Gas:      inner.Gas,
+			To:       inner.To,
+			Value:    inner.Value,
+			Data:     inner.Data,
+		}), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("Unsupported transaction type %d", txBytes[0])
+	}
+}
+
+// transactionData returns the normalized view of the unsigned transaction, as it's going to
+// be signed for the chain
+func transactionData(tx *types.Transaction, chainId *big.Int) map[string]interface{} {
+	result := map[string]interface{}{
+		"type":              hexutil.EncodeUint64(uint64(tx.Type())),
+		"chain_id":          chainId.String(),
+		"nonce":             tx.Nonce(),
+		"gas":               tx.Gas(),
+		"value":             tx.Value().String(),
+		"value_ether":       weiToEther(tx.Value()),
+		"contract_creation": tx.To() == nil,
+		"data_size":         len(tx.Data()),
+		"sighash":           transactionSigner(tx.Type(), chainId).Hash(tx).Hex(),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		result["max_fee_per_gas"] = tx.GasFeeCap().String()
+		result["max_priority_fee_per_gas"] = tx.GasTipCap().String()
+	} else {
+		result["gas_price"] = tx.GasPrice().String()
+	}
+	if tx.To() != nil {
+		result["to"] = strings.ToLower(tx.To().Hex())
+		if len(tx.Data()) >= 4 {
+			result["selector"] = hexutil.Encode(tx.Data()[:4])
+		}
+	}
+	if len(tx.AccessList()) > 0 {
+		result["access_list"] = tx.AccessList()
+	}
+	return result
+}
+
+// weiToEther formats the amount in wei as a decimal amount of ether, without trailing zeros
+func weiToEther(wei *big.Int) string {
+	ether := new(big.Rat).SetFrac(wei, big.NewInt(params.Ether)).FloatString(18)
+	ether = strings.TrimRight(ether, "0")
+	return strings.TrimSuffix(ether, ".")
+}
@@ -0,0 +1,146 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeTestTx(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.UpdateOperation, "decode")
+	req.Storage = storage
+	req.Data = data
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	to := common.HexToAddress(allowedTo)
+	calldata := hexutil.MustDecode("0xa9059cbb000000000000000000000000f809410b0d6f047c603deb311979cd413e025a840000000000000000000000000000000000000000000000000000000000000001")
+	unsigned := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     7,
+		GasTipCap: big.NewInt(2000000000),
+		GasFeeCap: big.NewInt(50000000000),
+		Gas:       60000,
+		To:        &to,
+		Value:     big.NewInt(1500000000000000000),
+		Data:      calldata,
+	})
+	sighash := types.NewLondonSigner(big.NewInt(1)).Hash(unsigned).Hex()
+
+	// from the fields of the sign endpoint
+	resp, err := decodeTestTx(t, b, storage, map[string]interface{}{
+		"to":                   allowedTo,
+		"data":                 hexutil.Encode(calldata),
+		"value":                "1500000000000000000",
+		"nonce":                "7",
+		"gas":                  "60000",
+		"maxFeePerGas":         "50000000000",
+		"maxPriorityFeePerGas": "2000000000",
+		"chainId":              "1",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x2", resp.Data["type"])
+	assert.Equal("1", resp.Data["chain_id"])
+	assert.Equal(uint64(7), resp.Data["nonce"])
+	assert.Equal(uint64(60000), resp.Data["gas"])
+	assert.Equal("50000000000", resp.Data["max_fee_per_gas"])
+	assert.Equal("2000000000", resp.Data["max_priority_fee_per_gas"])
+	assert.Nil(resp.Data["gas_price"])
+	assert.Equal(allowedTo, resp.Data["to"])
+	assert.Equal("1500000000000000000", resp.Data["value"])
+	assert.Equal("1.5", resp.Data["value_ether"])
+	assert.Equal(false, resp.Data["contract_creation"])
+	assert.Equal("0xa9059cbb", resp.Data["selector"])
+	assert.Equal(sighash, resp.Data["sighash"])
+	assert.Nil(resp.Data["from"])
+
+	// unsigned, with zero signature values as encoded by go-ethereum
+	unsignedBytes, _ := unsigned.MarshalBinary()
+	resp, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": hexutil.Encode(unsignedBytes)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(sighash, resp.Data["sighash"])
+	assert.Equal("0xa9059cbb", resp.Data["selector"])
+	assert.Nil(resp.Data["from"])
+
+	// unsigned, without signature values as encoded by ethers
+	payload, _ := rlp.EncodeToBytes(&unsignedDynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     7,
+		GasTipCap: big.NewInt(2000000000),
+		GasFeeCap: big.NewInt(50000000000),
+		Gas:       60000,
+		To:        &to,
+		Value:     big.NewInt(1500000000000000000),
+		Data:      calldata,
+	})
+	resp, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": hexutil.Encode(append([]byte{types.DynamicFeeTxType}, payload...))})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(sighash, resp.Data["sighash"])
+
+	// unsigned EIP-155 legacy contract creation, with the chain ID in place of 'v'
+	legacy, _ := rlp.EncodeToBytes([]interface{}{uint64(3), big.NewInt(1000), uint64(21000), []byte{}, big.NewInt(1), []byte{0x60, 0x80}, big.NewInt(5), uint64(0), uint64(0)})
+	resp, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": hexutil.Encode(legacy)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x0", resp.Data["type"])
+	assert.Equal("5", resp.Data["chain_id"])
+	assert.Equal("1000", resp.Data["gas_price"])
+	assert.Equal(true, resp.Data["contract_creation"])
+	assert.Equal("0.000000000000000001", resp.Data["value_ether"])
+	assert.Nil(resp.Data["to"])
+	assert.Nil(resp.Data["selector"])
+
+	// signed, with the sender
+	signed, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1000000000000000000", "nonce": "1", "chainId": "3"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": signed.Data["signed_transaction"]})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testAddress, resp.Data["from"])
+	assert.Equal(signed.Data["transaction_hash"], resp.Data["transaction_hash"])
+	assert.Equal("3", resp.Data["chain_id"])
+	assert.Equal("1", resp.Data["value_ether"])
+
+	_, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": "0xzz"})
+	assert.Contains(err.Error(), "Invalid hex value for the 'transaction' field")
+	_, err = decodeTestTx(t, b, storage, map[string]interface{}{"transaction": "0x05c0"})
+	assert.Contains(err.Error(), "Failed to decode the transaction")
+}
@@ -0,0 +1,315 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// DefaultDeletedRetention is how long deleted accounts can be restored, unless configured otherwise
+const DefaultDeletedRetention = 7 * 24 * time.Hour
+
+// DeletedAccount is the tombstone of a deleted account, stored at deleted/<address> until it's
+// restored or purged. The name of the account is released on deletion, and claimed back on restore.
+type DeletedAccount struct {
+	Account   *Account  `json:"account"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (b *backend) deleteAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	address := data.Get("name").(string)
+	account, err := b.retrieveAccount(ctx, req, address)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the account by address", "address", address, "error", err)
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+
+	lock := locksutil.LockForKey(b.locks, "deleted/"+account.Address)
+	lock.Lock()
+	defer lock.Unlock()
+	defer b.lockAccount(account.Address)()
+	// read again, it may have been updated before the locks were taken
+	current, err := b.retrieveAccount(ctx, req, address)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if current.Address != account.Address {
+		return nil, fmt.Errorf("Account %s was renamed while being deleted, try again", address)
+	}
+	account = current
+
+	// the tombstone is written first, so that a failure can never lose the key
+	deleted := &DeletedAccount{
+		Account:   account,
+		DeletedAt: time.Now(),
+	}
+	entry, _ := logical.StorageEntryJSON(fmt.Sprintf("deleted/%s", account.Address), deleted)
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the deleted account to storage", "address", account.Address, "error", err)
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("accounts/%s", account.Address)); err != nil {
+		b.Logger().Error("Failed to delete the account from storage", "address", address, "error", err)
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("metadata/%s", account.Address)); err != nil {
+		b.Logger().Error("Failed to delete the account metadata from storage", "address", address, "error", err)
+		return nil, err
+	}
+	if account.Name != "" {
+		if err := req.Storage.Delete(ctx, fmt.Sprintf("aliases/%s", account.Name)); err != nil {
+			b.Logger().Error("Failed to delete the account name from storage", "name", account.Name, "error", err)
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (b *backend) listDeletedAccounts(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "deleted/")
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the list of deleted accounts", "error", err)
+		return nil, err
+	}
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) readDeletedAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	address, err := deletedAddress(data)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := b.retrieveDeletedAccount(ctx, req.Storage, address)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, nil
+	}
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: deletedAccountData(deleted, config),
+	}, nil
+}
+
+func (b *backend) restoreAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	address, err := deletedAddress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := locksutil.LockForKey(b.locks, "deleted/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+	defer b.lockAccount(address)()
+
+	deleted, err := b.retrieveDeletedAccount(ctx, req.Storage, address)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, fmt.Errorf("Deleted account %s does not exist", address)
+	}
+	existing, err := b.retrieveAccount(ctx, req, address)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Account %s already exists, purge the deleted account instead", address)
+	}
+
+	account := deleted.Account
+	name := account.Name
+	account.Name = ""
+	warning := ""
+	if name != "" {
+		defer b.lockAccountName(name)()
+		inUse, err := b.accountNameInUse(ctx, req.Storage, name, account.Address)
+		if err != nil {
+			return nil, err
+		}
+		if inUse {
+			warning = fmt.Sprintf("Account name %s is now used by another account, the account was restored without a name", name)
+		} else if err := b.setAccountName(ctx, req.Storage, account, name); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.saveAccount(ctx, req, account); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("deleted/%s", address)); err != nil {
+		b.Logger().Error("Failed to delete the restored account from the deleted accounts", "address", address, "error", err)
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: accountData(account),
+	}
+	if warning != "" {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+func (b *backend) purgeAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	address, err := deletedAddress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := locksutil.LockForKey(b.locks, "deleted/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	deleted, err := b.retrieveDeletedAccount(ctx, req.Storage, address)
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		return nil, fmt.Errorf("Deleted account %s does not exist", address)
+	}
+	return nil, b.purgeDeletedAccount(ctx, req.Storage, address)
+}
+
+// purgeDeletedAccounts destroys the deleted accounts past the retention period of the mount
+func (b *backend) purgeDeletedAccounts(ctx context.Context, storage logical.Storage) error {
+	addresses, err := storage.List(ctx, "deleted/")
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+	config, err := b.retrieveConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, address := range addresses {
+		if err := b.purgeExpiredAccount(ctx, storage, address, config, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *backend) purgeExpiredAccount(ctx context.Context, storage logical.Storage, address string, config *Config, now time.Time) error {
+	lock := locksutil.LockForKey(b.locks, "deleted/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	deleted, err := b.retrieveDeletedAccount(ctx, storage, address)
+	if err != nil {
+		return err
+	}
+	if deleted == nil || now.Before(config.purgeAt(deleted)) {
+		return nil
+	}
+	if err := b.purgeDeletedAccount(ctx, storage, address); err != nil {
+		return err
+	}
+	b.Logger().Info("Purged deleted account past retention", "address", address)
+	return nil
+}
+
+// purgeDeletedAccount destroys the deleted account, along with its spends and nonces, which are
+// kept while the account can be restored. The caller must hold the lock of the deleted account.
+func (b *backend) purgeDeletedAccount(ctx context.Context, storage logical.Storage, address string) error {
+	if err := storage.Delete(ctx, fmt.Sprintf("deleted/%s", address)); err != nil {
+		b.Logger().Error("Failed to purge the deleted account from storage", "address", address, "error", err)
+		return err
+	}
+	// an account imported again with the same key since owns them now
+	live, err := storage.Get(ctx, fmt.Sprintf("accounts/%s", address))
+	if err != nil {
+		return err
+	}
+	if live != nil {
+		return nil
+	}
+	if err := storage.Delete(ctx, fmt.Sprintf("spend/%s", address)); err != nil {
+		b.Logger().Error("Failed to delete the spends of the account from storage", "address", address, "error", err)
+		return err
+	}
+	if err := b.deleteNonces(ctx, storage, address); err != nil {
+		b.Logger().Error("Failed to delete the nonces of the account from storage", "address", address, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (b *backend) retrieveDeletedAccount(ctx context.Context, storage logical.Storage, address string) (*DeletedAccount, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("deleted/%s", address))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the deleted account", "address", address, "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var deleted DeletedAccount
+	if err := entry.DecodeJSON(&deleted); err != nil {
+		b.Logger().Error("Failed to decode the deleted account", "address", address, "error", err)
+		return nil, err
+	}
+	return &deleted, nil
+}
+
+// deletedAddress returns the storage key of the deleted account. Deleted accounts can only be
+// referenced by their address, as their name is released.
+func deletedAddress(data *framework.FieldData) (string, error) {
+	address := data.Get("address").(string)
+	if !addressRegexp.MatchString(address) {
+		return "", fmt.Errorf("Invalid address %s", address)
+	}
+	address = strings.ToLower(address)
+	if address[:2] != "0x" {
+		address = "0x" + address
+	}
+	return address, nil
+}
+
+// purgeAt returns when the deleted account is purged, under the current retention of the mount
+func (c *Config) purgeAt(deleted *DeletedAccount) time.Time {
+	return deleted.DeletedAt.Add(time.Duration(c.DeletedRetention) * time.Second)
+}
+
+func deletedAccountData(deleted *DeletedAccount, config *Config) map[string]interface{} {
+	result := map[string]interface{}{
+		"address":    deleted.Account.Address,
+		"deleted_at": deleted.DeletedAt.UTC().Format(time.RFC3339),
+		"purge_at":   config.purgeAt(deleted).UTC().Format(time.RFC3339),
+	}
+	if deleted.Account.Name != "" {
+		result["name"] = deleted.Account.Name
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "treasury",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/treasury")
+	req.Storage = storage
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account does not exist", err.Error())
+
+	req = logical.TestRequest(t, logical.ListOperation, "deleted")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]string{testAddress}, resp.Data["keys"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "deleted/"+testAddress)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Equal("treasury", resp.Data["name"])
+	deletedAt, _ := time.Parse(time.RFC3339, resp.Data["deleted_at"].(string))
+	purgeAt, _ := time.Parse(time.RFC3339, resp.Data["purge_at"].(string))
+	assert.Equal(DefaultDeletedRetention, purgeAt.Sub(deletedAt))
+
+	// the account, with its name, comes back on restore
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+testAddress+"/restore")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Equal("treasury", resp.Data["name"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/treasury")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(testAddress, resp.Data["address"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "deleted/"+testAddress)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp)
+
+	// the periodic function only purges the accounts past retention
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	req = logical.TestRequest(t, logical.RollbackOperation, "")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	deleted, _ := b.(*backend).retrieveDeletedAccount(context.Background(), storage, testAddress)
+	assert.NotNil(deleted)
+
+	deleted.DeletedAt = time.Now().Add(-DefaultDeletedRetention - time.Minute)
+	entry, _ := logical.StorageEntryJSON("deleted/"+testAddress, deleted)
+	storage.Put(context.Background(), entry)
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	deleted, _ = b.(*backend).retrieveDeletedAccount(context.Background(), storage, testAddress)
+	assert.Nil(deleted)
+}
+
+func TestRestoreNameTaken(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"newName": "treasury",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/treasury")
+	req.Storage = storage
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// another account takes the name meanwhile
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name": "treasury",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	other := resp.Data["address"].(string)
+
+	// the account is restored without its name
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+testAddress+"/restore")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Nil(resp.Data["name"])
+	assert.Equal([]string{"Account name treasury is now used by another account, the account was restored without a name"}, resp.Warnings)
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/treasury")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(other, resp.Data["address"])
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp.Data["name"])
+	deleted, _ := b.(*backend).retrieveDeletedAccount(context.Background(), storage, testAddress)
+	assert.Nil(deleted)
+}
+
+func TestSoftDeleteFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/not-an-address/restore")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid address not-an-address", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+allowedTo+"/purge")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Deleted account "+allowedTo+" does not exist", err.Error())
+
+	// an account imported again with the same key can't be overwritten by the deleted one
+	importTestAccount(t, b, storage)
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+testAddress+"/restore")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+testAddress+" already exists, purge the deleted account instead", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+testAddress+"/purge")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(testAddress, resp.Data["address"])
+}
+
+// listFailingStorage fails the listings of the entries under the prefix
+type listFailingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *listFailingStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	if strings.HasPrefix(prefix, s.prefix) {
+		return nil, fmt.Errorf("Bang for List!")
+	}
+	return s.Storage.List(ctx, prefix)
+}
+
+func TestPeriodicFuncRunsEveryTask(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	deleted, _ := b.(*backend).retrieveDeletedAccount(context.Background(), storage, testAddress)
+	deleted.DeletedAt = time.Now().Add(-DefaultDeletedRetention - time.Minute)
+	entry, _ := logical.StorageEntryJSON("deleted/"+testAddress, deleted)
+	storage.Put(context.Background(), entry)
+
+	// the approvals can't be expired, the deleted accounts are still purged and the error reported
+	req = logical.TestRequest(t, logical.RollbackOperation, "")
+	req.Storage = &listFailingStorage{storage, "approvals/"}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "Bang for List!")
+	deleted, _ = b.(*backend).retrieveDeletedAccount(context.Background(), storage, testAddress)
+	assert.Nil(deleted)
+}
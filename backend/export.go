@@ -0,0 +1,170 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// AlgorithmRSAOAEP encrypts the exported key with RSA-OAEP, using SHA-256
+	AlgorithmRSAOAEP string = "rsa-oaep-sha256"
+	// AlgorithmECIES encrypts the exported key with ECIES on the secp256k1 curve, as implemented by go-ethereum
+	AlgorithmECIES string = "ecies-secp256k1"
+
+	minRSAKeyBits = 2048
+)
+
+// keyEncrypter encrypts the raw bytes of a private key to a public key supplied by the caller
+type keyEncrypter struct {
+	algorithm string
+	rsaKey    *rsa.PublicKey
+	eciesKey  *ecies.PublicKey
+}
+
+func (b *backend) exportAccountEncrypted(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	publicKey := data.Get("publicKey").(string)
+	if publicKey == "" {
+		return b.exportAccountKeystore(ctx, req, data)
+	}
+	if data.Get("password").(string) != "" {
+		return nil, fmt.Errorf("Only one of 'password' or 'publicKey' can be provided")
+	}
+	encrypter, err := keyEncrypterFromInput(publicKey)
+	if err != nil {
+		b.Logger().Error("Failed to parse the public key to encrypt the export with", "error", err)
+		return nil, err
+	}
+
+	address := data.Get("name").(string)
+	b.Logger().Info("Retrieving account for address", "address", address)
+	account, err := b.retrieveAccount(ctx, req, address)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if err := b.checkExportAllowed(ctx, req.Storage, account); err != nil {
+		return nil, err
+	}
+
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
+
+	keyBytes := crypto.FromECDSA(privateKey)
+	defer zeroBytes(keyBytes)
+	encrypted, err := encrypter.encrypt(keyBytes)
+	if err != nil {
+		b.Logger().Error("Failed to encrypt the exported key", "error", err)
+		return nil, fmt.Errorf("Failed to encrypt the exported key")
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"address":      account.Address,
+			"algorithm":    encrypter.algorithm,
+			"encryptedKey": base64.StdEncoding.EncodeToString(encrypted),
+		},
+	}, nil
+}
+
+// keyEncrypterFromInput accepts either a PEM encoded RSA public key, or a hex encoded
+// secp256k1 public key, compressed or not
+func keyEncrypterFromInput(input string) (*keyEncrypter, error) {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(input))
+		if block == nil {
+			return nil, fmt.Errorf("'publicKey' is not a valid PEM block")
+		}
+		var parsed interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("Unsupported PEM block type %s in 'publicKey'", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse 'publicKey': %v", err)
+		}
+		rsaKey, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("'publicKey' must be an RSA public key when PEM encoded")
+		}
+		if rsaKey.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("'publicKey' must be an RSA key of at least %d bits", minRSAKeyBits)
+		}
+		return &keyEncrypter{algorithm: AlgorithmRSAOAEP, rsaKey: rsaKey}, nil
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("'publicKey' must be a PEM encoded RSA public key or a hexidecimal secp256k1 public key")
+	}
+	if len(raw) == 64 {
+		// the uncompressed format without its prefix, as returned for the accounts
+		raw = append([]byte{4}, raw...)
+	}
+	var pub *ecdsa.PublicKey
+	switch len(raw) {
+	case 33:
+		pub, err = crypto.DecompressPubkey(raw)
+	case 65:
+		pub, err = crypto.UnmarshalPubkey(raw)
+	default:
+		return nil, fmt.Errorf("'publicKey' must be a 33, 64 or 65-byte secp256k1 public key")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse 'publicKey': %v", err)
+	}
+	return &keyEncrypter{algorithm: AlgorithmECIES, eciesKey: ecies.ImportECDSAPublic(pub)}, nil
+}
+
+// encrypt returns the ciphertext of the raw key bytes
+func (e *keyEncrypter) encrypt(plaintext []byte) ([]byte, error) {
+	if e.rsaKey != nil {
+		return rsa.EncryptOAEP(sha256.New(), rand.Reader, e.rsaKey, plaintext, nil)
+	}
+	return ecies.Encrypt(rand.Reader, e.eciesKey, plaintext, nil, nil)
+}
+
+// zeroBytes clears the memory of a secret after use
+func zeroBytes(secret []byte) {
+	for i := range secret {
+		secret[i] = 0
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func exportEncrypted(t *testing.T, b logical.Backend, storage logical.Storage, publicKey string) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"publicKey": publicKey,
+	}
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestExportEncryptedRSA(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	resp, err := exportEncrypted(t, b, storage, string(publicKey))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Equal(AlgorithmRSAOAEP, resp.Data["algorithm"])
+	assert.Nil(resp.Data["privateKey"])
+
+	ciphertext, _ := base64.StdEncoding.DecodeString(resp.Data["encryptedKey"].(string))
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, ciphertext, nil)
+	assert.Nil(err)
+	assert.Equal(testPrivateKey, hex.EncodeToString(plaintext))
+
+	// PKCS #1 encoding
+	publicKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+	resp, err = exportEncrypted(t, b, storage, string(publicKey))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ciphertext, _ = base64.StdEncoding.DecodeString(resp.Data["encryptedKey"].(string))
+	plaintext, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, ciphertext, nil)
+	assert.Nil(err)
+	assert.Equal(testPrivateKey, hex.EncodeToString(plaintext))
+}
+
+func TestExportEncryptedECIES(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	recipient, _ := crypto.GenerateKey()
+	for _, publicKey := range []string{
+		hexutil.Encode(crypto.FromECDSAPub(&recipient.PublicKey)),
+		hexutil.Encode(crypto.FromECDSAPub(&recipient.PublicKey))[4:],
+		hexutil.Encode(crypto.CompressPubkey(&recipient.PublicKey)),
+	} {
+		resp, err := exportEncrypted(t, b, storage, publicKey)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		assert.Equal(AlgorithmECIES, resp.Data["algorithm"])
+
+		ciphertext, _ := base64.StdEncoding.DecodeString(resp.Data["encryptedKey"].(string))
+		plaintext, err := ecies.ImportECDSA(recipient).Decrypt(ciphertext, nil, nil)
+		assert.Nil(err)
+		assert.Equal(testPrivateKey, hex.EncodeToString(plaintext))
+	}
+}
+
+func TestExportEncryptedFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"publicKey": "0x04",
+		"password":  "secret",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Only one of 'password' or 'publicKey' can be provided", err.Error())
+
+	_, err = exportEncrypted(t, b, storage, "not a key")
+	assert.Equal("'publicKey' must be a PEM encoded RSA public key or a hexidecimal secp256k1 public key", err.Error())
+
+	_, err = exportEncrypted(t, b, storage, "0x0102")
+	assert.Equal("'publicKey' must be a 33, 64 or 65-byte secp256k1 public key", err.Error())
+
+	_, err = exportEncrypted(t, b, storage, "-----BEGIN PUBLIC KEY-----\n-----END PUBLIC KEY-----")
+	assert.Contains(err.Error(), "Failed to parse 'publicKey'")
+
+	_, err = exportEncrypted(t, b, storage, "-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----")
+	assert.Equal("Unsupported PEM block type CERTIFICATE in 'publicKey'", err.Error())
+
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	der, _ := x509.MarshalPKIXPublicKey(&smallKey.PublicKey)
+	_, err = exportEncrypted(t, b, storage, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	assert.Equal("'publicKey' must be an RSA key of at least 2048 bits", err.Error())
+
+	// the export restrictions still apply
+	recipient, _ := crypto.GenerateKey()
+	publicKey := hexutil.Encode(crypto.FromECDSAPub(&recipient.PublicKey))
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"exportable": false,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = exportEncrypted(t, b, storage, publicKey)
+	assert.Equal("Account "+testAddress+" is not exportable", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowExport": false,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": "8d2e1e3e4b0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b8c0b6c6b",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.CreateOperation, "export/accounts/"+resp.Data["address"].(string))
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"publicKey": publicKey,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Exporting private keys is disabled by the mount configuration", err.Error())
+}
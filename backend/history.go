@@ -0,0 +1,227 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// DefaultHistoryPageSize is the number of entries returned by a history read without a limit
+const DefaultHistoryPageSize = 100
+
+// HistoryEntry records a transaction signed by an account, stored at history/<address>/<id>.
+// The ID starts with the zero-padded signing time, so the entries of an account are listed
+// in the order they were signed.
+type HistoryEntry struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	RequestedBy string    `json:"requested_by,omitempty"`
+	ChainID     string    `json:"chain_id"`
+	Nonce       uint64    `json:"nonce"`
+	// empty for contract creations
+	To              string `json:"to,omitempty"`
+	Value           string `json:"value"`
+	TransactionHash string `json:"transaction_hash"`
+	// position in the signing log of the mount, entries recorded before the log was
+	// introduced have none
+	Sequence     uint64 `json:"sequence,omitempty"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+}
+
+// recordHistory adds the signed transaction to the history of the account, and chains it to
+// the signing log of the mount
+func (b *backend) recordHistory(ctx context.Context, storage logical.Storage, account *Account, signedTx *types.Transaction, chainId *big.Int, requestedBy string) error {
+	lock := locksutil.LockForKey(b.locks, logHeadPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// taken with the lock held, so the times of the entries follow their order in the log
+	now := time.Now().UTC()
+	entry := &HistoryEntry{
+		ID:              fmt.Sprintf("%019d-%s", now.UnixNano(), signedTx.Hash().Hex()[2:10]),
+		Time:            now,
+		RequestedBy:     requestedBy,
+		ChainID:         chainId.String(),
+		Nonce:           signedTx.Nonce(),
+		Value:           signedTx.Value().String(),
+		TransactionHash: signedTx.Hash().Hex(),
+	}
+	if signedTx.To() != nil {
+		entry.To = strings.ToLower(signedTx.To().Hex())
+	}
+
+	head, err := b.appendToLog(ctx, storage, account.Address, entry)
+	if err != nil {
+		return err
+	}
+	storageEntry, err := logical.StorageEntryJSON(fmt.Sprintf("history/%s/%s", account.Address, entry.ID), entry)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, storageEntry); err != nil {
+		b.Logger().Error("Failed to save the signing history of the account", "address", account.Address, "error", err)
+		return err
+	}
+	return b.saveLogHead(ctx, storage, head)
+}
+
+func (b *backend) readHistory(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, err := b.retrieveAccount(ctx, req, nameOrAddress)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	limit := data.Get("limit").(int)
+	if limit <= 0 {
+		return nil, fmt.Errorf("Invalid 'limit' value")
+	}
+	after := data.Get("after").(string)
+
+	ids, err := req.Storage.List(ctx, fmt.Sprintf("history/%s/", account.Address))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing history of the account", "address", account.Address, "error", err)
+		return nil, err
+	}
+	// the storage doesn't promise any order, the IDs start with the zero-padded time of the entry
+	sort.Strings(ids)
+	entries := []map[string]interface{}{}
+	next := ""
+	for _, id := range ids {
+		if id <= after {
+			continue
+		}
+		if len(entries) == limit {
+			next = entries[len(entries)-1]["id"].(string)
+			break
+		}
+		entry, err := b.retrieveHistoryEntry(ctx, req.Storage, account.Address, id)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, historyEntryData(entry))
+		}
+	}
+
+	result := map[string]interface{}{
+		"address": account.Address,
+		"entries": entries,
+	}
+	// the ID to pass as 'after' to read the next page
+	if next != "" {
+		result["next"] = next
+	}
+	return &logical.Response{
+		Data: result,
+	}, nil
+}
+
+// expireHistory removes the history entries older than the retention period of the mount
+func (b *backend) expireHistory(ctx context.Context, storage logical.Storage) error {
+	config, err := b.retrieveConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config.HistoryRetention == 0 {
+		return nil
+	}
+	cutoffTime := time.Now().Add(-time.Duration(config.HistoryRetention) * time.Second)
+	cutoff := cutoffTime.UnixNano()
+
+	addresses, err := storage.List(ctx, "history/")
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		address = strings.TrimSuffix(address, "/")
+		ids, err := storage.List(ctx, fmt.Sprintf("history/%s/", address))
+		if err != nil {
+			return err
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			// the entries are sorted oldest first
+			if historyEntryTime(id) >= cutoff {
+				break
+			}
+			if err := storage.Delete(ctx, fmt.Sprintf("history/%s/%s", address, id)); err != nil {
+				b.Logger().Error("Failed to remove the expired history entry", "address", address, "id", id, "error", err)
+				return err
+			}
+		}
+	}
+	return b.pruneLog(ctx, storage, cutoffTime)
+}
+
+func (b *backend) retrieveHistoryEntry(ctx context.Context, storage logical.Storage, address, id string) (*HistoryEntry, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("history/%s/%s", address, id))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the history entry", "address", address, "id", id, "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var historyEntry HistoryEntry
+	if err := entry.DecodeJSON(&historyEntry); err != nil {
+		b.Logger().Error("Failed to decode the history entry", "address", address, "id", id, "error", err)
+		return nil, err
+	}
+	return &historyEntry, nil
+}
+
+// historyEntryTime returns the signing time in nanoseconds encoded in the ID of the entry
+func historyEntryTime(id string) int64 {
+	nanos, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return nanos
+}
+
+func historyEntryData(entry *HistoryEntry) map[string]interface{} {
+	result := map[string]interface{}{
+		"id":               entry.ID,
+		"time":             entry.Time.Format(time.RFC3339Nano),
+		"chain_id":         entry.ChainID,
+		"nonce":            entry.Nonce,
+		"value":            entry.Value,
+		"transaction_hash": entry.TransactionHash,
+	}
+	if entry.RequestedBy != "" {
+		result["requested_by"] = entry.RequestedBy
+	}
+	if entry.To != "" {
+		result["to"] = entry.To
+	}
+	if entry.Sequence != 0 {
+		result["sequence"] = entry.Sequence
+		result["previous_hash"] = entry.PreviousHash
+		result["hash"] = entry.Hash
+	}
+	return result
+}
@@ -0,0 +1,174 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestHistory(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/history")
+	req.Storage = storage
+	req.Data = data
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	resp, err := readTestHistory(t, b, storage, nil)
+	assert.Nil(err)
+	assert.Equal(0, len(resp.Data["entries"].([]map[string]interface{})))
+
+	hashes := []string{}
+	for i := 0; i < 3; i++ {
+		req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign")
+		req.Storage = storage
+		req.EntityID = "entity1"
+		req.Data = map[string]interface{}{"data": "0x", "to": allowedTo, "value": "100", "nonce": fmt.Sprintf("%d", i), "chainId": "1"}
+		resp, err := b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		hashes = append(hashes, resp.Data["transaction_hash"].(string))
+	}
+
+	// transactions rejected by the policy are not recorded
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"maxValue": "10",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "100", "chainId": "1"})
+	assert.NotNil(err)
+
+	resp, err = readTestHistory(t, b, storage, map[string]interface{}{"limit": 2})
+	assert.Nil(err)
+	entries := resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(2, len(entries))
+	assert.Equal(hashes[0], entries[0]["transaction_hash"])
+	assert.Equal(hashes[1], entries[1]["transaction_hash"])
+	assert.Equal("entity1", entries[0]["requested_by"])
+	assert.Equal("1", entries[0]["chain_id"])
+	assert.Equal(uint64(1), entries[1]["nonce"])
+	assert.Equal(allowedTo, entries[0]["to"])
+	assert.Equal("100", entries[0]["value"])
+	assert.Equal(entries[1]["id"], resp.Data["next"])
+
+	resp, err = readTestHistory(t, b, storage, map[string]interface{}{"limit": 2, "after": resp.Data["next"]})
+	assert.Nil(err)
+	entries = resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(1, len(entries))
+	assert.Equal(hashes[2], entries[0]["transaction_hash"])
+	assert.Nil(resp.Data["next"])
+
+	_, err = readTestHistory(t, b, storage, map[string]interface{}{"limit": 0})
+	assert.Equal("Invalid 'limit' value", err.Error())
+
+	// the entries past retention are removed by the periodic function
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"historyRetention": "1h",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(int64(3600), resp.Data["history_retention"])
+
+	old := &HistoryEntry{
+		ID:              fmt.Sprintf("%019d-00000000", time.Now().Add(-2*time.Hour).UnixNano()),
+		Time:            time.Now().Add(-2 * time.Hour),
+		ChainID:         "1",
+		Value:           "0",
+		TransactionHash: "0x00",
+	}
+	entry, _ := logical.StorageEntryJSON("history/"+testAddress+"/"+old.ID, old)
+	storage.Put(context.Background(), entry)
+	resp, _ = readTestHistory(t, b, storage, nil)
+	assert.Equal(4, len(resp.Data["entries"].([]map[string]interface{})))
+
+	req = logical.TestRequest(t, logical.RollbackOperation, "")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	resp, _ = readTestHistory(t, b, storage, nil)
+	entries = resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(3, len(entries))
+	assert.Equal(hashes[0], entries[0]["transaction_hash"])
+}
+
+func TestHistoryUnsortedListing(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	hashes := []string{}
+	for i := 0; i < 3; i++ {
+		resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": fmt.Sprintf("%d", i), "chainId": "1"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		hashes = append(hashes, resp.Data["transaction_hash"].(string))
+	}
+	unsorted := &reversedListStorage{storage, "history/"}
+
+	// the pages follow the order of the entries
+	resp, err := readTestHistory(t, b, unsorted, map[string]interface{}{"limit": 2})
+	assert.Nil(err)
+	entries := resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(hashes[0], entries[0]["transaction_hash"])
+	assert.Equal(hashes[1], entries[1]["transaction_hash"])
+	resp, err = readTestHistory(t, b, unsorted, map[string]interface{}{"limit": 2, "after": resp.Data["next"]})
+	assert.Nil(err)
+	entries = resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(1, len(entries))
+	assert.Equal(hashes[2], entries[0]["transaction_hash"])
+
+	// the old entries are removed even when listed after the recent ones
+	req := logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"historyRetention": "1h",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	old := &HistoryEntry{
+		ID:              fmt.Sprintf("%019d-00000000", time.Now().Add(-2*time.Hour).UnixNano()),
+		Time:            time.Now().Add(-2 * time.Hour),
+		ChainID:         "1",
+		Value:           "0",
+		TransactionHash: "0x00",
+	}
+	entry, _ := logical.StorageEntryJSON("history/"+testAddress+"/"+old.ID, old)
+	storage.Put(context.Background(), entry)
+	assert.Nil(b.(*backend).expireHistory(context.Background(), unsorted))
+	resp, _ = readTestHistory(t, b, storage, nil)
+	entries = resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(3, len(entries))
+	assert.Equal(hashes[0], entries[0]["transaction_hash"])
+}
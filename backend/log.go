@@ -0,0 +1,535 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	logHeadPath         = "log/head"
+	logAttestationPath  = "log/attestation"
+	attestationKeyPath  = "attestation_key"
+	logAttestationTitle = "ethsign signing log"
+	// logHashVersion prefixes the encoding of the hashed history entries, and must change
+	// with it
+	logHashVersion byte = 2
+)
+
+// LogHead is the last entry of the signing log of the mount, which chains the history entries
+// of all the accounts in the order they were signed. Each entry includes the hash of the
+// previous one, so an entry can't be edited or removed without breaking the chain.
+type LogHead struct {
+	Sequence uint64 `json:"sequence"`
+	Hash     string `json:"hash"`
+	// the last entry removed once past the history retention, the chain starts after it
+	BaseSequence uint64 `json:"base_sequence,omitempty"`
+	BaseHash     string `json:"base_hash,omitempty"`
+}
+
+// LogPointer locates the history entry with a sequence number, stored at log/entries/<sequence>
+type LogPointer struct {
+	Address string    `json:"address"`
+	ID      string    `json:"id"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+}
+
+// LogAttestation is the signature of the chain head by the attestation key of the mount
+type LogAttestation struct {
+	Sequence  uint64    `json:"sequence"`
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message"`
+	Signature string    `json:"signature"`
+}
+
+// AttestationKey is the secp256k1 key the chain head is signed with, generated on first use.
+// It's not an account, and can't be used to sign anything else.
+type AttestationKey struct {
+	Key string `json:"key"`
+}
+
+// appendToLog chains the history entry to the signing log of the mount, setting its sequence
+// number and hashes. It must be called with the log lock held, before the entry is saved.
+func (b *backend) appendToLog(ctx context.Context, storage logical.Storage, address string, entry *HistoryEntry) (*LogHead, error) {
+	head, err := b.retrieveLogHead(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	entry.Sequence = head.Sequence + 1
+	entry.PreviousHash = head.Hash
+	entry.Hash = historyEntryHash(address, entry)
+
+	pointer := &LogPointer{
+		Address: address,
+		ID:      entry.ID,
+		Hash:    entry.Hash,
+		Time:    entry.Time,
+	}
+	storageEntry, err := logical.StorageEntryJSON(logPointerPath(entry.Sequence), pointer)
+	if err != nil {
+		return nil, err
+	}
+	if err := storage.Put(ctx, storageEntry); err != nil {
+		b.Logger().Error("Failed to save the signing log entry", "sequence", entry.Sequence, "error", err)
+		return nil, err
+	}
+	head.Sequence = entry.Sequence
+	head.Hash = entry.Hash
+	return head, nil
+}
+
+func (b *backend) readLog(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	head, attestation, err := b.snapshotLog(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	fromAttestation := data.Get("fromAttestation").(bool)
+	// verified without the lock, so the signatures aren't held up by the walk of the chain
+	from, verified, verifyErr := b.verifyLogFrom(ctx, req.Storage, head, attestation, fromAttestation)
+	if verifyErr != nil {
+		// the start of the log may have been pruned past the history retention meanwhile
+		current, currentAttestation, err := b.snapshotLog(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if current.BaseSequence != head.BaseSequence {
+			head, attestation = current, currentAttestation
+			from, verified, verifyErr = b.verifyLogFrom(ctx, req.Storage, head, attestation, fromAttestation)
+		}
+	}
+	if verifyErr != nil {
+		b.Logger().Warn("The signing log failed verification", "error", verifyErr)
+	}
+
+	result := map[string]interface{}{
+		"head_sequence":    head.Sequence,
+		"head_hash":        head.Hash,
+		"base_sequence":    head.BaseSequence,
+		"verified":         verifyErr == nil,
+		"verified_from":    from,
+		"verified_entries": verified,
+	}
+	if verifyErr != nil {
+		result["error"] = verifyErr.Error()
+	}
+	if attestation != nil {
+		result["attestation"] = map[string]interface{}{
+			"sequence":  attestation.Sequence,
+			"hash":      attestation.Hash,
+			"time":      attestation.Time.Format(time.RFC3339),
+			"message":   attestation.Message,
+			"signature": attestation.Signature,
+		}
+		key, err := b.retrieveAttestationKey(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		defer ZeroKey(key)
+		result["attestation_address"] = strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex())
+	}
+	return &logical.Response{
+		Data: result,
+	}, nil
+}
+
+// snapshotLog returns the head of the signing log and its last attestation, read together
+// with the log lock held
+func (b *backend) snapshotLog(ctx context.Context, storage logical.Storage) (*LogHead, *LogAttestation, error) {
+	lock := locksutil.LockForKey(b.locks, logHeadPath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	head, err := b.retrieveLogHead(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	attestation, err := b.retrieveLogAttestation(ctx, storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	return head, attestation, nil
+}
+
+// verifyLogFrom verifies all the entries from the base of the log, or only the entries after
+// the last attestation when fromAttestation is set and it's within the log. The attestation
+// is only trusted once its signature and the entry it attests are checked. It returns the
+// sequence the verification started after, and the number of entries verified.
+func (b *backend) verifyLogFrom(ctx context.Context, storage logical.Storage, head *LogHead, attestation *LogAttestation, fromAttestation bool) (uint64, uint64, error) {
+	if fromAttestation && attestation != nil && attestation.Sequence > head.BaseSequence && attestation.Sequence <= head.Sequence {
+		if err := b.checkAttestation(ctx, storage, attestation); err != nil {
+			return attestation.Sequence, 0, err
+		}
+		verified, err := b.verifyLog(ctx, storage, head, attestation.Sequence, attestation.Hash)
+		return attestation.Sequence, verified, err
+	}
+	previous := head.BaseHash
+	if previous == "" {
+		previous = common.Hash{}.Hex()
+	}
+	verified, err := b.verifyLog(ctx, storage, head, head.BaseSequence, previous)
+	return head.BaseSequence, verified, err
+}
+
+// checkAttestation makes sure the attestation was signed by the attestation key of the mount,
+// and that the entry it attests still has the attested hash
+func (b *backend) checkAttestation(ctx context.Context, storage logical.Storage, attestation *LogAttestation) error {
+	message := fmt.Sprintf("%s %d %s", logAttestationTitle, attestation.Sequence, attestation.Hash)
+	signature, err := hexutil.Decode(attestation.Signature)
+	if err != nil || len(signature) != crypto.SignatureLength || attestation.Message != message {
+		return fmt.Errorf("The log attestation is malformed")
+	}
+	signature[crypto.RecoveryIDOffset] -= 27
+	signer, err := crypto.SigToPub(accounts.TextHash([]byte(message)), signature)
+	if err != nil {
+		return fmt.Errorf("The log attestation is malformed")
+	}
+	key, err := b.retrieveAttestationKey(ctx, storage)
+	if err != nil {
+		return err
+	}
+	defer ZeroKey(key)
+	if crypto.PubkeyToAddress(*signer) != crypto.PubkeyToAddress(key.PublicKey) {
+		return fmt.Errorf("The log attestation is not signed by the attestation key")
+	}
+
+	pointer, err := b.retrieveLogPointer(ctx, storage, attestation.Sequence)
+	if err != nil {
+		return err
+	}
+	entry, err := b.retrieveHistoryEntry(ctx, storage, pointer.Address, pointer.ID)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("Log entry %d is missing from the history of account %s", attestation.Sequence, pointer.Address)
+	}
+	hash := historyEntryHash(pointer.Address, entry)
+	if entry.Sequence != attestation.Sequence || hash != entry.Hash || hash != pointer.Hash || hash != attestation.Hash {
+		return fmt.Errorf("Log entry %d does not match its attestation", attestation.Sequence)
+	}
+	return nil
+}
+
+// verifyLog walks the signing log from the entry after the given one up to the head, and
+// returns the number of entries verified before the first one that's missing or doesn't
+// match its hashes
+func (b *backend) verifyLog(ctx context.Context, storage logical.Storage, head *LogHead, fromSequence uint64, fromHash string) (uint64, error) {
+	sequences, err := storage.List(ctx, "log/entries/")
+	if err != nil {
+		return 0, err
+	}
+	// the storage doesn't promise any order, the keys are zero-padded to sort by sequence
+	sort.Strings(sequences)
+	previous := fromHash
+	expected := fromSequence + 1
+	verified := uint64(0)
+	for _, key := range sequences {
+		sequence, _ := strconv.ParseUint(key, 10, 64)
+		if sequence <= fromSequence {
+			continue
+		}
+		// left behind by a signature that failed to be recorded, and overwritten by the next one,
+		// or appended after the head was read
+		if sequence > head.Sequence {
+			break
+		}
+		if sequence != expected {
+			return verified, fmt.Errorf("Log entry %d is missing", expected)
+		}
+		pointer, err := b.retrieveLogPointer(ctx, storage, sequence)
+		if err != nil {
+			return verified, err
+		}
+		entry, err := b.retrieveHistoryEntry(ctx, storage, pointer.Address, pointer.ID)
+		if err != nil {
+			return verified, err
+		}
+		if entry == nil {
+			return verified, fmt.Errorf("Log entry %d is missing from the history of account %s", sequence, pointer.Address)
+		}
+		if entry.Sequence != sequence || entry.PreviousHash != previous {
+			return verified, fmt.Errorf("Log entry %d is not chained to the previous entry", sequence)
+		}
+		if hash := historyEntryHash(pointer.Address, entry); hash != entry.Hash || hash != pointer.Hash {
+			return verified, fmt.Errorf("Log entry %d does not match its hash", sequence)
+		}
+		previous = entry.Hash
+		expected++
+		verified++
+	}
+	if expected-1 != head.Sequence || (head.Sequence > 0 && previous != head.Hash) {
+		return verified, fmt.Errorf("Log head %d does not match the last entry", head.Sequence)
+	}
+	return verified, nil
+}
+
+// pruneLog removes the pointers to the entries removed past the history retention from the
+// start of the log, and moves its base after them
+func (b *backend) pruneLog(ctx context.Context, storage logical.Storage, cutoff time.Time) error {
+	lock := locksutil.LockForKey(b.locks, logHeadPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	head, err := b.retrieveLogHead(ctx, storage)
+	if err != nil {
+		return err
+	}
+	sequences, err := storage.List(ctx, "log/entries/")
+	if err != nil {
+		return err
+	}
+	sort.Strings(sequences)
+	pruned := false
+	for _, key := range sequences {
+		sequence, _ := strconv.ParseUint(key, 10, 64)
+		pointer, err := b.retrieveLogPointer(ctx, storage, sequence)
+		if err != nil {
+			return err
+		}
+		if !pointer.Time.Before(cutoff) {
+			break
+		}
+		entry, err := b.retrieveHistoryEntry(ctx, storage, pointer.Address, pointer.ID)
+		if err != nil {
+			return err
+		}
+		// an entry within the log can only be missing if it was tampered with
+		if entry != nil {
+			break
+		}
+		if err := storage.Delete(ctx, logPointerPath(sequence)); err != nil {
+			return err
+		}
+		head.BaseSequence = sequence
+		head.BaseHash = pointer.Hash
+		pruned = true
+	}
+	if !pruned {
+		return nil
+	}
+	return b.saveLogHead(ctx, storage, head)
+}
+
+// attestLog signs the chain head with the attestation key of the mount, if it changed since
+// the last attestation and the attestation interval of the mount passed
+func (b *backend) attestLog(ctx context.Context, storage logical.Storage) error {
+	config, err := b.retrieveConfig(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if config.AttestationInterval == 0 {
+		return nil
+	}
+
+	lock := locksutil.LockForKey(b.locks, logHeadPath)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	head, err := b.retrieveLogHead(ctx, storage)
+	if err != nil {
+		return err
+	}
+	last, err := b.retrieveLogAttestation(ctx, storage)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if head.Sequence == 0 {
+		return nil
+	}
+	if last != nil && (last.Sequence == head.Sequence || now.Before(last.Time.Add(time.Duration(config.AttestationInterval)*time.Second))) {
+		return nil
+	}
+
+	key, err := b.retrieveAttestationKey(ctx, storage)
+	if err != nil {
+		return err
+	}
+	defer ZeroKey(key)
+	message := fmt.Sprintf("%s %d %s", logAttestationTitle, head.Sequence, head.Hash)
+	signature, err := crypto.Sign(accounts.TextHash([]byte(message)), key)
+	if err != nil {
+		return err
+	}
+	// same encoding as the "sign-message" signatures, so they can be checked with "verify"
+	signature[crypto.RecoveryIDOffset] += 27
+
+	attestation := &LogAttestation{
+		Sequence:  head.Sequence,
+		Hash:      head.Hash,
+		Time:      now,
+		Message:   message,
+		Signature: hexutil.Encode(signature),
+	}
+	entry, err := logical.StorageEntryJSON(logAttestationPath, attestation)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the signing log attestation", "error", err)
+		return err
+	}
+	b.Logger().Info("Attested the signing log head", "sequence", head.Sequence, "hash", head.Hash)
+	return nil
+}
+
+func (b *backend) retrieveLogHead(ctx context.Context, storage logical.Storage) (*LogHead, error) {
+	entry, err := storage.Get(ctx, logHeadPath)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing log head", "error", err)
+		return nil, err
+	}
+	head := &LogHead{Hash: common.Hash{}.Hex()}
+	if entry == nil {
+		return head, nil
+	}
+	if err := entry.DecodeJSON(head); err != nil {
+		b.Logger().Error("Failed to decode the signing log head", "error", err)
+		return nil, err
+	}
+	return head, nil
+}
+
+func (b *backend) saveLogHead(ctx context.Context, storage logical.Storage, head *LogHead) error {
+	entry, err := logical.StorageEntryJSON(logHeadPath, head)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the signing log head", "error", err)
+		return err
+	}
+	return nil
+}
+
+func (b *backend) retrieveLogPointer(ctx context.Context, storage logical.Storage, sequence uint64) (*LogPointer, error) {
+	entry, err := storage.Get(ctx, logPointerPath(sequence))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("Log entry %d is missing", sequence)
+	}
+	var pointer LogPointer
+	if err := entry.DecodeJSON(&pointer); err != nil {
+		return nil, err
+	}
+	return &pointer, nil
+}
+
+func (b *backend) retrieveLogAttestation(ctx context.Context, storage logical.Storage) (*LogAttestation, error) {
+	entry, err := storage.Get(ctx, logAttestationPath)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing log attestation", "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var attestation LogAttestation
+	if err := entry.DecodeJSON(&attestation); err != nil {
+		return nil, err
+	}
+	return &attestation, nil
+}
+
+// retrieveAttestationKey returns the attestation key of the mount, generating it if needed.
+// The key must be zeroed after use.
+func (b *backend) retrieveAttestationKey(ctx context.Context, storage logical.Storage) (*ecdsa.PrivateKey, error) {
+	lock := locksutil.LockForKey(b.locks, attestationKeyPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := storage.Get(ctx, attestationKeyPath)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the attestation key", "error", err)
+		return nil, err
+	}
+	if entry != nil {
+		var stored AttestationKey
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+		return crypto.HexToECDSA(stored.Key)
+	}
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	entry, _ = logical.StorageEntryJSON(attestationKeyPath, &AttestationKey{Key: hexutil.Encode(crypto.FromECDSA(key))[2:]})
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the attestation key", "error", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// historyEntryHash returns the keccak256 hash of the entry of the account without its hash.
+// The fields are encoded in a fixed order after the logHashVersion byte: the integers as 8
+// bytes big-endian, the time as its Unix nanoseconds, and the strings prefixed by their
+// length as 4 bytes. The address of the account is included, so the entry can't be moved to
+// the history of another account.
+func historyEntryHash(address string, entry *HistoryEntry) string {
+	var encoded bytes.Buffer
+	encoded.WriteByte(logHashVersion)
+	writeLogUint64(&encoded, entry.Sequence)
+	writeLogString(&encoded, entry.PreviousHash)
+	writeLogString(&encoded, address)
+	writeLogString(&encoded, entry.ID)
+	writeLogUint64(&encoded, uint64(entry.Time.UnixNano()))
+	writeLogString(&encoded, entry.RequestedBy)
+	writeLogString(&encoded, entry.ChainID)
+	writeLogUint64(&encoded, entry.Nonce)
+	writeLogString(&encoded, entry.To)
+	writeLogString(&encoded, entry.Value)
+	writeLogString(&encoded, entry.TransactionHash)
+	return crypto.Keccak256Hash(encoded.Bytes()).Hex()
+}
+
+func writeLogUint64(buf *bytes.Buffer, value uint64) {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], value)
+	buf.Write(encoded[:])
+}
+
+func writeLogString(buf *bytes.Buffer, value string) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	buf.Write(length[:])
+	buf.WriteString(value)
+}
+
+func logPointerPath(sequence uint64) string {
+	return fmt.Sprintf("log/entries/%020d", sequence)
+}
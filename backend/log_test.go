@@ -0,0 +1,313 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestLog(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) *logical.Response {
+	req := logical.TestRequest(t, logical.ReadOperation, "log")
+	req.Storage = storage
+	req.Data = data
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return resp
+}
+
+func TestSigningLog(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	resp := readTestLog(t, b, storage, nil)
+	assert.Equal(uint64(0), resp.Data["head_sequence"])
+	assert.Equal(common.Hash{}.Hex(), resp.Data["head_hash"])
+	assert.Equal(true, resp.Data["verified"])
+
+	// the entries of all the accounts are chained
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	other := resp.Data["address"].(string)
+	for i := 0; i < 4; i++ {
+		account := testAddress
+		if i%2 == 1 {
+			account = other
+		}
+		req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+account+"/sign")
+		req.Storage = storage
+		req.Data = map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": fmt.Sprintf("%d", i), "chainId": "1"}
+		if _, err := b.HandleRequest(context.Background(), req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	resp, _ = readTestHistory(t, b, storage, nil)
+	entries := resp.Data["entries"].([]map[string]interface{})
+	assert.Equal(uint64(1), entries[0]["sequence"])
+	assert.Equal(common.Hash{}.Hex(), entries[0]["previous_hash"])
+	assert.Equal(uint64(3), entries[1]["sequence"])
+	last := entries[1]["hash"]
+
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(uint64(4), resp.Data["head_sequence"])
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(4), resp.Data["verified_entries"])
+	assert.Nil(resp.Data["attestation"])
+
+	// the head is signed by the attestation key, verifiable as a personal message
+	req = logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"attestationInterval": "24h",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.RollbackOperation, "")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	resp = readTestLog(t, b, storage, nil)
+	attestation := resp.Data["attestation"].(map[string]interface{})
+	assert.Equal(uint64(4), attestation["sequence"])
+	assert.Equal(resp.Data["head_hash"], attestation["hash"])
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(0), resp.Data["verified_from"])
+	assert.Equal(uint64(4), resp.Data["verified_entries"])
+	// the entries after the attestation can be verified alone
+	resp = readTestLog(t, b, storage, map[string]interface{}{"fromAttestation": true})
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(4), resp.Data["verified_from"])
+	assert.Equal(uint64(0), resp.Data["verified_entries"])
+	attestation = resp.Data["attestation"].(map[string]interface{})
+	attestationAddress := resp.Data["attestation_address"].(string)
+	assert.NotEqual(testAddress, attestationAddress)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "verify")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message":   attestation["message"],
+		"signature": attestation["signature"],
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(attestationAddress, resp.Data["address"])
+	assert.Equal(false, resp.Data["account_exists"])
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "4", "chainId": "1"})
+	assert.Nil(err)
+	resp = readTestLog(t, b, storage, map[string]interface{}{"fromAttestation": true})
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(4), resp.Data["verified_from"])
+	assert.Equal(uint64(1), resp.Data["verified_entries"])
+
+	// the attestation is only trusted with a valid signature, and while the attested entry is intact
+	stored, _ := b.(*backend).retrieveLogAttestation(context.Background(), storage)
+	forged := *stored
+	forged.Hash = common.Hash{}.Hex()
+	forged.Message = fmt.Sprintf("%s %d %s", logAttestationTitle, forged.Sequence, forged.Hash)
+	storageEntry, _ := logical.StorageEntryJSON(logAttestationPath, &forged)
+	storage.Put(context.Background(), storageEntry)
+	resp = readTestLog(t, b, storage, map[string]interface{}{"fromAttestation": true})
+	assert.Equal(false, resp.Data["verified"])
+	assert.Equal("The log attestation is not signed by the attestation key", resp.Data["error"])
+	storageEntry, _ = logical.StorageEntryJSON(logAttestationPath, stored)
+	storage.Put(context.Background(), storageEntry)
+
+	attestedPointer, _ := b.(*backend).retrieveLogPointer(context.Background(), storage, 4)
+	attested, _ := b.(*backend).retrieveHistoryEntry(context.Background(), storage, attestedPointer.Address, attestedPointer.ID)
+	attested.Value = "1000000"
+	storageEntry, _ = logical.StorageEntryJSON("history/"+attestedPointer.Address+"/"+attested.ID, attested)
+	storage.Put(context.Background(), storageEntry)
+	resp = readTestLog(t, b, storage, map[string]interface{}{"fromAttestation": true})
+	assert.Equal(false, resp.Data["verified"])
+	assert.Equal("Log entry 4 does not match its attestation", resp.Data["error"])
+	attested.Value = "0"
+	storageEntry, _ = logical.StorageEntryJSON("history/"+attestedPointer.Address+"/"+attested.ID, attested)
+	storage.Put(context.Background(), storageEntry)
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(5), resp.Data["verified_entries"])
+
+	// so does moving an entry to the history of another account
+	pointer, _ := b.(*backend).retrieveLogPointer(context.Background(), storage, 5)
+	moved, _ := b.(*backend).retrieveHistoryEntry(context.Background(), storage, testAddress, pointer.ID)
+	storageEntry, _ = logical.StorageEntryJSON("history/"+other+"/"+moved.ID, moved)
+	storage.Put(context.Background(), storageEntry)
+	pointer.Address = other
+	storageEntry, _ = logical.StorageEntryJSON(logPointerPath(5), pointer)
+	storage.Put(context.Background(), storageEntry)
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(false, resp.Data["verified"])
+	assert.Equal("Log entry 5 does not match its hash", resp.Data["error"])
+	pointer.Address = testAddress
+	storageEntry, _ = logical.StorageEntryJSON(logPointerPath(5), pointer)
+	storage.Put(context.Background(), storageEntry)
+	storage.Delete(context.Background(), "history/"+other+"/"+moved.ID)
+
+	// editing an entry breaks the chain, even before the attestation
+	entry, _ := b.(*backend).retrieveHistoryEntry(context.Background(), storage, testAddress, entries[1]["id"].(string))
+	entry.Value = "1000000"
+	storageEntry, _ = logical.StorageEntryJSON("history/"+testAddress+"/"+entry.ID, entry)
+	storage.Put(context.Background(), storageEntry)
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(false, resp.Data["verified"])
+	assert.Equal(uint64(0), resp.Data["verified_from"])
+	assert.Equal(uint64(2), resp.Data["verified_entries"])
+	assert.Equal("Log entry 3 does not match its hash", resp.Data["error"])
+
+	// so does removing one, unless it's past retention
+	entry.Value = "0"
+	storageEntry, _ = logical.StorageEntryJSON("history/"+testAddress+"/"+entry.ID, entry)
+	storage.Put(context.Background(), storageEntry)
+	assert.Equal(last, entry.Hash)
+	storage.Delete(context.Background(), "history/"+testAddress+"/"+entries[0]["id"].(string))
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(false, resp.Data["verified"])
+	assert.Equal("Log entry 1 is missing from the history of account "+testAddress, resp.Data["error"])
+
+	pointer, _ = b.(*backend).retrieveLogPointer(context.Background(), storage, 1)
+	pointer.Time = time.Now().Add(-2 * time.Hour)
+	storageEntry, _ = logical.StorageEntryJSON(logPointerPath(1), pointer)
+	storage.Put(context.Background(), storageEntry)
+	if err := b.(*backend).pruneLog(context.Background(), storage, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp = readTestLog(t, b, storage, nil)
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(1), resp.Data["base_sequence"])
+	assert.Equal(uint64(1), resp.Data["verified_from"])
+	assert.Equal(uint64(4), resp.Data["verified_entries"])
+}
+
+// reversedListStorage lists the keys under the prefix in reverse order, as the storage
+// doesn't promise any order
+type reversedListStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *reversedListStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := s.Storage.List(ctx, prefix)
+	if err != nil || !strings.HasPrefix(prefix, s.prefix) {
+		return keys, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	return keys, nil
+}
+
+func TestSigningLogUnsortedListing(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	for i := 0; i < 3; i++ {
+		_, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": fmt.Sprintf("%d", i), "chainId": "1"})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	unsorted := &reversedListStorage{storage, "log/entries/"}
+
+	resp := readTestLog(t, b, unsorted, nil)
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(3), resp.Data["verified_entries"])
+
+	// only the start of the log is pruned
+	for _, sequence := range []uint64{1, 2} {
+		pointer, _ := b.(*backend).retrieveLogPointer(context.Background(), storage, sequence)
+		pointer.Time = time.Now().Add(-2 * time.Hour)
+		storageEntry, _ := logical.StorageEntryJSON(logPointerPath(sequence), pointer)
+		storage.Put(context.Background(), storageEntry)
+		storage.Delete(context.Background(), "history/"+testAddress+"/"+pointer.ID)
+	}
+	if err := b.(*backend).pruneLog(context.Background(), unsorted, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp = readTestLog(t, b, unsorted, nil)
+	assert.Equal(true, resp.Data["verified"])
+	assert.Equal(uint64(2), resp.Data["base_sequence"])
+	assert.Equal(uint64(1), resp.Data["verified_entries"])
+}
+
+func TestHistoryEntryHashGolden(t *testing.T) {
+	assert := assert.New(t)
+
+	// changing these vectors breaks the verification of the existing logs, the encoding
+	// must get a new logHashVersion instead
+	entry := &HistoryEntry{
+		ID:              "1700000000000000000-0a1b2c3d",
+		Time:            time.Unix(1700000000, 0).UTC(),
+		RequestedBy:     "token-accessor",
+		ChainID:         "1",
+		Nonce:           7,
+		To:              "0xd5bcc62d9b1087a5cfec116c24d6187dd40fdf8a",
+		Value:           "1000",
+		TransactionHash: "0x0a1b2c3d00000000000000000000000000000000000000000000000000000000",
+		Sequence:        42,
+		PreviousHash:    "0x7c1d8f0dfe0c0e9bd4a0c6a4e43e3a29b40c34cfa4a3e5cc8c21b4c3bf68fd12",
+	}
+	assert.Equal("0xfd3e26ae46581f9fd0e13bf73afd332c16216f5981dde032e288c78c521b7d3c", historyEntryHash(testAddress, entry))
+
+	// the hash itself is not hashed
+	entry.Hash = "0xfd3e26ae46581f9fd0e13bf73afd332c16216f5981dde032e288c78c521b7d3c"
+	assert.Equal(entry.Hash, historyEntryHash(testAddress, entry))
+
+	// contract creations have no recipient
+	entry.To = ""
+	assert.Equal("0x151ad2aadf6e09cae31d4c5d5eb1a49a93cac26c7344dce5b53251eadddb77e5", historyEntryHash(testAddress, entry))
+
+	// every field is covered, and moving bytes between adjacent strings changes the hash
+	hashes := map[string]bool{historyEntryHash(testAddress, entry): true}
+	for _, edit := range []func(e *HistoryEntry){
+		func(e *HistoryEntry) { e.ID = "1700000000000000000-0a1b2c3e" },
+		func(e *HistoryEntry) { e.Time = e.Time.Add(time.Nanosecond) },
+		func(e *HistoryEntry) { e.RequestedBy = "" },
+		func(e *HistoryEntry) { e.RequestedBy = "token-accessor1"; e.ChainID = "" },
+		func(e *HistoryEntry) { e.Nonce = 8 },
+		func(e *HistoryEntry) { e.Value = "1001" },
+		func(e *HistoryEntry) { e.TransactionHash = "" },
+		func(e *HistoryEntry) { e.Sequence = 43 },
+		func(e *HistoryEntry) { e.PreviousHash = common.Hash{}.Hex() },
+	} {
+		edited := *entry
+		edit(&edited)
+		hash := historyEntryHash(testAddress, &edited)
+		assert.False(hashes[hash], "%+v", edited)
+		hashes[hash] = true
+	}
+
+	// the entry can't be moved to the history of another account
+	assert.False(hashes[historyEntryHash(allowedTo, entry)])
+}
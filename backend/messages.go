@@ -0,0 +1,99 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) signMessage(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	from := data.Get("name").(string)
+
+	message, err := messageFromInput(data.Get("message").(string), data.Get("encoding").(string))
+	if err != nil {
+		b.Logger().Error("Failed to decode the message to sign", "error", err)
+		return nil, err
+	}
+
+	account, err := b.retrieveAccount(ctx, req, from)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing account", "address", from, "error", err)
+		return nil, fmt.Errorf("Error retrieving signing account %s", from)
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Signing account %s does not exist", from)
+	}
+	if violation := account.Policy.CheckMessage(); violation != nil {
+		b.Logger().Warn("Message rejected by the signing policy", "address", account.Address, "rule", violation.Rule, "error", violation.Message)
+		return violation.Response(), logical.ErrPermissionDenied
+	}
+
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
+
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.checkDataSize("message", len(message)); err != nil {
+		return nil, err
+	}
+
+	hash := accounts.TextHash(message)
+	signature, err := crypto.Sign(hash, privateKey)
+	if err != nil {
+		b.Logger().Error("Failed to sign the message", "error", err)
+		return nil, err
+	}
+	// use the 27/28 recovery id expected by "personal_sign" consumers such as ecrecover
+	signature[crypto.RecoveryIDOffset] += 27
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"message_hash": hexutil.Encode(hash),
+			"signature":    hexutil.Encode(signature),
+		},
+	}, nil
+}
+
+// messageFromInput returns the raw bytes of a message submitted as plain text or hex
+func messageFromInput(message, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf8", "utf-8":
+		return []byte(message), nil
+	case "hex":
+		if !strings.HasPrefix(message, "0x") {
+			message = "0x" + message
+		}
+		decoded, err := hexutil.Decode(message)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid hex value for the 'message' field: %v", err)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("Unsupported message encoding %s, must be 'utf8' or 'hex'", encoding)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPrivateKey = "ec85999367d32fbbe02dd600a2a44550b95274cc67d14375a9f0bce233f13ad2"
+const testAddress = "0xd5bcc62d9b1087a5cfec116c24d6187dd40fdf8a"
+
+func importTestAccount(t *testing.T, b logical.Backend, storage logical.Storage) {
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": testPrivateKey,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	privateKey, _ := crypto.HexToECDSA(testPrivateKey)
+
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message": "Login challenge: 12345",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	hash := accounts.TextHash([]byte("Login challenge: 12345"))
+	assert.Equal(hexutil.Encode(hash), resp.Data["message_hash"])
+
+	signature, _ := hexutil.Decode(resp.Data["signature"].(string))
+	assert.Equal(65, len(signature))
+	assert.True(signature[64] == 27 || signature[64] == 28)
+
+	expected, _ := crypto.Sign(hash, privateKey)
+	expected[64] += 27
+	assert.Equal(hexutil.Encode(expected), resp.Data["signature"])
+
+	signature[64] -= 27
+	pubKey, err := crypto.SigToPub(hash, signature)
+	assert.Nil(err)
+	assert.Equal(testAddress, strings.ToLower(crypto.PubkeyToAddress(*pubKey).Hex()))
+
+	// hex encoded message, with and without the "0x" prefix
+	req.Data = map[string]interface{}{
+		"message":  "0xdeadbeef",
+		"encoding": "hex",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(hexutil.Encode(accounts.TextHash([]byte{0xde, 0xad, 0xbe, 0xef})), resp.Data["message_hash"])
+
+	req.Data["message"] = "deadbeef"
+	resp2, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(resp.Data["signature"], resp2.Data["signature"])
+}
+
+func TestSignMessageRequiredApprovals(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"requiredApprovals": 2,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [required_approvals]: messages cannot be signed by accounts that require approvals", resp.Data["error"])
+	assert.Nil(resp.Data["signature"])
+}
+
+func TestSignMessageFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Signing account "+testAddress+" does not exist", err.Error())
+
+	req.Data = map[string]interface{}{
+		"message":  "0xabc",
+		"encoding": "hex",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid hex value for the 'message' field: hex string of odd length", err.Error())
+
+	req.Data = map[string]interface{}{
+		"message":  "hello",
+		"encoding": "base64",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Unsupported message encoding base64, must be 'utf8' or 'hex'", err.Error())
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	sm := newStorageMock()
+	sm.switches[1] = 2
+	req.Storage = sm
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Error reconstructing private key from retrieved hex", err.Error())
+}
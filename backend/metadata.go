@@ -0,0 +1,153 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+var labelKeyRegexp = regexp.MustCompile(`^\w([\w./-]*\w)?$`)
+
+// AccountMetadata is the part of the account record returned by the account listing. It is
+// saved apart from the seal-wrapped record, so that listing the accounts doesn't read their keys
+type AccountMetadata struct {
+	Name        string            `json:"name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Description string            `json:"description,omitempty"`
+	CreatedAt   time.Time         `json:"created_at,omitempty"`
+	CreatedBy   string            `json:"created_by,omitempty"`
+}
+
+// metadata returns the metadata of the account
+func (account *Account) metadata() *AccountMetadata {
+	return &AccountMetadata{
+		Name:        account.Name,
+		Labels:      account.Labels,
+		Description: account.Description,
+		CreatedAt:   account.CreatedAt,
+		CreatedBy:   account.CreatedBy,
+	}
+}
+
+// setCreator records when, and by which entity, the new account was created
+func (account *Account) setCreator(req *logical.Request) {
+	account.CreatedAt = time.Now().UTC()
+	account.CreatedBy = req.EntityID
+}
+
+// setMetadata updates the labels and the description of the account, with the fields in the request
+func (account *Account) setMetadata(data *framework.FieldData) error {
+	if labels, ok := data.GetOk("labels"); ok {
+		if err := validateLabels(labels.(map[string]string)); err != nil {
+			return err
+		}
+		account.Labels = labels.(map[string]string)
+		if len(account.Labels) == 0 {
+			account.Labels = nil
+		}
+	}
+	if description, ok := data.GetOk("description"); ok {
+		account.Description = description.(string)
+	}
+	return nil
+}
+
+// hasLabels tells whether the account has all the given labels, with the same values
+func (metadata *AccountMetadata) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if label, ok := metadata.Labels[key]; !ok || label != value {
+			return false
+		}
+	}
+	return true
+}
+
+func validateLabels(labels map[string]string) error {
+	for key := range labels {
+		if !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("Invalid label %s, must only contain letters, digits, '_', '-', '.' and '/'", key)
+		}
+	}
+	return nil
+}
+
+// retrieveAccountMetadata returns the metadata of the account, nil if the account doesn't exist
+func (b *backend) retrieveAccountMetadata(ctx context.Context, storage logical.Storage, address string) (*AccountMetadata, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("metadata/%s", address))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the account metadata", "address", address, "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		// accounts saved before their metadata was kept apart only have the account record,
+		// the key is left out when decoding it
+		if entry, err = storage.Get(ctx, fmt.Sprintf("accounts/%s", address)); err != nil {
+			b.Logger().Error("Failed to retrieve the account by address", "address", address, "error", err)
+			return nil, err
+		}
+		if entry == nil {
+			return nil, nil
+		}
+	}
+	var metadata AccountMetadata
+	if err := entry.DecodeJSON(&metadata); err != nil {
+		b.Logger().Error("Failed to decode the account metadata", "address", address, "error", err)
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// saveAccountMetadata writes the metadata of the account to its own entry
+func (b *backend) saveAccountMetadata(ctx context.Context, storage logical.Storage, account *Account) error {
+	entry, _ := logical.StorageEntryJSON(fmt.Sprintf("metadata/%s", account.Address), account.metadata())
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the account metadata", "address", account.Address, "error", err)
+		return err
+	}
+	return nil
+}
+
+// accountInfo returns the details of the account included in the account listing
+func accountInfo(metadata *AccountMetadata) map[string]interface{} {
+	result := map[string]interface{}{}
+	if metadata.Name != "" {
+		result["name"] = metadata.Name
+	}
+	addMetadata(result, metadata)
+	return result
+}
+
+// addMetadata adds the metadata that is set on the account to its public details
+func addMetadata(result map[string]interface{}, metadata *AccountMetadata) {
+	if len(metadata.Labels) > 0 {
+		result["labels"] = metadata.Labels
+	}
+	if metadata.Description != "" {
+		result["description"] = metadata.Description
+	}
+	// accounts created before the creation was recorded have no timestamp
+	if !metadata.CreatedAt.IsZero() {
+		result["created_at"] = metadata.CreatedAt.Format(time.RFC3339)
+	}
+	if metadata.CreatedBy != "" {
+		result["created_by"] = metadata.CreatedBy
+	}
+}
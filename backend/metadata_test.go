@@ -0,0 +1,193 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.EntityID = "entity1"
+	req.Data = map[string]interface{}{
+		"name":        "payments-hot",
+		"labels":      []string{"service=payments", "env=prod"},
+		"description": "Hot wallet of the payments service",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	payments := resp.Data["address"].(string)
+	assert.Equal(map[string]string{"service": "payments", "env": "prod"}, resp.Data["labels"])
+	assert.Equal("Hot wallet of the payments service", resp.Data["description"])
+	assert.Equal("entity1", resp.Data["created_by"])
+	assert.NotEmpty(resp.Data["created_at"])
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels": map[string]interface{}{"service": "settlement", "env": "prod"},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	settlement := resp.Data["address"].(string)
+	assert.Nil(resp.Data["created_by"])
+
+	// the listing returns the metadata, and can be filtered by labels
+	req = logical.TestRequest(t, logical.ListOperation, "accounts")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{payments, settlement}, resp.Data["keys"])
+	info := resp.Data["key_info"].(map[string]interface{})[payments].(map[string]interface{})
+	assert.Equal("payments-hot", info["name"])
+	assert.Equal("Hot wallet of the payments service", info["description"])
+	assert.Equal(map[string]string{"service": "payments", "env": "prod"}, info["labels"])
+
+	req = logical.TestRequest(t, logical.ListOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels": "env=prod",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.ElementsMatch([]string{payments, settlement}, resp.Data["keys"])
+
+	req = logical.TestRequest(t, logical.ListOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels": []string{"env=prod", "service=settlement"},
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]string{settlement}, resp.Data["keys"])
+
+	req = logical.TestRequest(t, logical.ListOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels": "env=staging",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp.Data["keys"])
+
+	// updates replace the labels, and keep the creation details
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/payments-hot")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels":      map[string]interface{}{},
+		"description": "",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp.Data["labels"])
+	assert.Nil(resp.Data["description"])
+	assert.Equal("entity1", resp.Data["created_by"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+settlement)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(map[string]string{"service": "settlement", "env": "prod"}, resp.Data["labels"])
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+settlement)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"labels": map[string]interface{}{"bad label": "x"},
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid label bad label, must only contain letters, digits, '_', '-', '.' and '/'", err.Error())
+}
+
+// accountGetFailingStorage fails the reads of the account records
+type accountGetFailingStorage struct {
+	logical.Storage
+}
+
+func (s *accountGetFailingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	if strings.HasPrefix(key, "accounts/") {
+		return nil, fmt.Errorf("Bang for Get!")
+	}
+	return s.Storage.Get(ctx, key)
+}
+
+func TestAccountListingMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": testPrivateKey,
+		"labels":     "env=prod",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+
+	// the listing doesn't read the account records
+	req = logical.TestRequest(t, logical.ListOperation, "accounts")
+	req.Storage = &accountGetFailingStorage{storage}
+	req.Data = map[string]interface{}{
+		"labels": "env=prod",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]string{testAddress}, resp.Data["keys"])
+	info := resp.Data["key_info"].(map[string]interface{})[testAddress].(map[string]interface{})
+	assert.Equal(map[string]string{"env": "prod"}, info["labels"])
+
+	// accounts saved before the metadata was kept apart are still listed with it
+	assert.Nil(storage.Delete(context.Background(), "metadata/"+testAddress))
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal([]string{testAddress}, resp.Data["keys"])
+	info = resp.Data["key_info"].(map[string]interface{})[testAddress].(map[string]interface{})
+	assert.Equal(map[string]string{"env": "prod"}, info["labels"])
+	assert.Nil(info["private_key"])
+
+	// the metadata goes away with the account
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"description": "Deposits",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	entry, _ := storage.Get(context.Background(), "metadata/"+testAddress)
+	assert.NotNil(entry)
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	entry, _ = storage.Get(context.Background(), "metadata/"+testAddress)
+	assert.Nil(entry)
+}
@@ -0,0 +1,237 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Nonces tracks the nonces allocated to the transactions of an account on a chain
+type Nonces struct {
+	// the nonce of the next transaction, unless one was released
+	Next uint64 `json:"next"`
+	// nonces that were allocated but never broadcast, reused first
+	Released []uint64 `json:"released,omitempty"`
+}
+
+// allocate returns the lowest released nonce, or the next one
+func (n *Nonces) allocate() uint64 {
+	if len(n.Released) > 0 {
+		nonce := n.Released[0]
+		n.Released = n.Released[1:]
+		return nonce
+	}
+	nonce := n.Next
+	n.Next++
+	return nonce
+}
+
+// release makes an allocated nonce available again
+func (n *Nonces) release(nonce uint64) error {
+	if nonce >= n.Next {
+		return fmt.Errorf("Nonce %d was not allocated", nonce)
+	}
+	for _, released := range n.Released {
+		if released == nonce {
+			return fmt.Errorf("Nonce %d was already released", nonce)
+		}
+	}
+	n.Released = append(n.Released, nonce)
+	sort.Slice(n.Released, func(i, j int) bool { return n.Released[i] < n.Released[j] })
+	// released nonces at the top are simply handed out again in order
+	for len(n.Released) > 0 && n.Released[len(n.Released)-1] == n.Next-1 {
+		n.Released = n.Released[:len(n.Released)-1]
+		n.Next--
+	}
+	return nil
+}
+
+// allocateNonce atomically reserves the nonce of the next transaction of the account on the chain
+func (b *backend) allocateNonce(ctx context.Context, storage logical.Storage, address string, chainId *big.Int) (uint64, error) {
+	lock := locksutil.LockForKey(b.locks, "nonces/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	nonces, err := b.retrieveNonces(ctx, storage, address, chainId)
+	if err != nil {
+		return 0, err
+	}
+	nonce := nonces.allocate()
+	if err := b.saveNonces(ctx, storage, address, chainId, nonces); err != nil {
+		return 0, err
+	}
+	return nonce, nil
+}
+
+// releaseNonce makes a nonce allocated to the account on the chain available again
+func (b *backend) releaseNonce(ctx context.Context, storage logical.Storage, address string, chainId *big.Int, nonce uint64) (*Nonces, error) {
+	lock := locksutil.LockForKey(b.locks, "nonces/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	nonces, err := b.retrieveNonces(ctx, storage, address, chainId)
+	if err != nil {
+		return nil, err
+	}
+	if err := nonces.release(nonce); err != nil {
+		return nil, err
+	}
+	if err := b.saveNonces(ctx, storage, address, chainId, nonces); err != nil {
+		return nil, err
+	}
+	return nonces, nil
+}
+
+func (b *backend) readNonces(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	account, chainId, err := b.nonceAccountAndChain(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+
+	nonces, err := b.retrieveNonces(ctx, req.Storage, account.Address, chainId)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: noncesData(chainId, nonces),
+	}, nil
+}
+
+func (b *backend) resetNonces(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	account, chainId, err := b.nonceAccountAndChain(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	rawNext, ok := data.GetOk("next")
+	if !ok {
+		return logical.ErrorResponse("'next' is required"), logical.ErrInvalidRequest
+	}
+	next, ok := math.ParseUint64(rawNext.(string))
+	if !ok {
+		return logical.ErrorResponse("Invalid 'next' value"), logical.ErrInvalidRequest
+	}
+
+	lock := locksutil.LockForKey(b.locks, "nonces/"+account.Address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	nonces := &Nonces{Next: next}
+	if err := b.saveNonces(ctx, req.Storage, account.Address, chainId, nonces); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("Reset the nonce of the account", "address", account.Address, "chainId", chainId, "next", next)
+	return &logical.Response{
+		Data: noncesData(chainId, nonces),
+	}, nil
+}
+
+func (b *backend) releaseNonceRequest(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	account, chainId, err := b.nonceAccountAndChain(ctx, req, data)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ok := math.ParseUint64(data.Get("nonce").(string))
+	if !ok {
+		return nil, fmt.Errorf("Invalid 'nonce' value")
+	}
+
+	nonces, err := b.releaseNonce(ctx, req.Storage, account.Address, chainId, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: noncesData(chainId, nonces),
+	}, nil
+}
+
+func (b *backend) nonceAccountAndChain(ctx context.Context, req *logical.Request, data *framework.FieldData) (*Account, *big.Int, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, err := b.retrieveAccount(ctx, req, nameOrAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil {
+		return nil, nil, fmt.Errorf("Account does not exist")
+	}
+	chainId, ok := math.ParseBig256(data.Get("chainId").(string))
+	if !ok || chainId.Sign() < 0 {
+		return nil, nil, fmt.Errorf("Invalid chain ID %s", data.Get("chainId").(string))
+	}
+	return account, chainId, nil
+}
+
+func (b *backend) retrieveNonces(ctx context.Context, storage logical.Storage, address string, chainId *big.Int) (*Nonces, error) {
+	path := fmt.Sprintf("nonces/%s/%s", address, chainId)
+	entry, err := storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the nonces of the account", "path", path, "error", err)
+		return nil, err
+	}
+	nonces := &Nonces{}
+	if entry == nil {
+		return nonces, nil
+	}
+	if err := entry.DecodeJSON(nonces); err != nil {
+		b.Logger().Error("Failed to decode the nonces of the account", "path", path, "error", err)
+		return nil, err
+	}
+	return nonces, nil
+}
+
+func (b *backend) saveNonces(ctx context.Context, storage logical.Storage, address string, chainId *big.Int, nonces *Nonces) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("nonces/%s/%s", address, chainId), nonces)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the nonces of the account", "address", address, "error", err)
+		return err
+	}
+	return nil
+}
+
+// deleteNonces removes the nonces of the account on all the chains
+func (b *backend) deleteNonces(ctx context.Context, storage logical.Storage, address string) error {
+	chainIds, err := storage.List(ctx, fmt.Sprintf("nonces/%s/", address))
+	if err != nil {
+		return err
+	}
+	for _, chainId := range chainIds {
+		if err := storage.Delete(ctx, fmt.Sprintf("nonces/%s/%s", address, chainId)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func noncesData(chainId *big.Int, nonces *Nonces) map[string]interface{} {
+	released := nonces.Released
+	if released == nil {
+		released = []uint64{}
+	}
+	return map[string]interface{}{
+		"chain_id":   chainId.String(),
+		"next_nonce": nonces.Next,
+		"released":   released,
+	}
+}
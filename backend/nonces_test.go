@@ -0,0 +1,171 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signedNonce(t *testing.T, resp *logical.Response) uint64 {
+	signedTxBytes, _ := hexutil.Decode(resp.Data["signed_transaction"].(string))
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(signedTxBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return tx.Nonce()
+}
+
+func TestManagedNonces(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"managedNonces": true,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(true, resp.Data["managed_nonces"])
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "5", "chainId": "1"})
+	assert.Equal("'nonce' cannot be provided, the nonces of account "+testAddress+" are managed by the plugin", err.Error())
+
+	// concurrent requests never get the same nonce
+	var wg sync.WaitGroup
+	nonces := make(chan uint64, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+			assert.Nil(err)
+			assert.Equal(signedNonce(t, resp), resp.Data["nonce"])
+			nonces <- signedNonce(t, resp)
+		}()
+	}
+	wg.Wait()
+	close(nonces)
+	seen := map[uint64]bool{}
+	for nonce := range nonces {
+		seen[nonce] = true
+	}
+	assert.Equal(10, len(seen))
+	for i := uint64(0); i < 10; i++ {
+		assert.True(seen[i])
+	}
+
+	// each chain has its own counter
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "maxFeePerGas": "1", "chainId": "5"})
+	assert.Nil(err)
+	assert.Equal(uint64(0), signedNonce(t, resp))
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/nonces/1")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("1", resp.Data["chain_id"])
+	assert.Equal(uint64(10), resp.Data["next_nonce"])
+
+	// released nonces are allocated first
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/nonces/1/release")
+	req.Storage = storage
+	req.Data = map[string]interface{}{"nonce": "4"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]uint64{4}, resp.Data["released"])
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Nonce 4 was already released", err.Error())
+	req.Data = map[string]interface{}{"nonce": "10"}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Nonce 10 was not allocated", err.Error())
+
+	// releasing the last nonce rolls the counter back
+	req.Data = map[string]interface{}{"nonce": "9"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(uint64(9), resp.Data["next_nonce"])
+	assert.Equal([]uint64{4}, resp.Data["released"])
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+	assert.Equal(uint64(4), signedNonce(t, resp))
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+	assert.Equal(uint64(9), signedNonce(t, resp))
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/nonces/1")
+	req.Storage = storage
+	req.Data = map[string]interface{}{"next": "0x64"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(uint64(100), resp.Data["next_nonce"])
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+	assert.Equal(uint64(100), signedNonce(t, resp))
+
+	req.Data = map[string]interface{}{"next": "abc"}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Invalid 'next' value", resp.Error().Error())
+
+	// the counter is never reset without an explicit value
+	req.Data = map[string]interface{}{}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("'next' is required", resp.Error().Error())
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/nonces/1")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal(uint64(101), resp.Data["next_nonce"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/nonces/mainnet")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid chain ID mainnet", err.Error())
+
+	// nonces are kept while the account can be restored, and removed when it's purged
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	keys, _ := storage.List(context.Background(), "nonces/"+testAddress+"/")
+	assert.NotEmpty(keys)
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+testAddress+"/purge")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	keys, _ = storage.List(context.Background(), "nonces/"+testAddress+"/")
+	assert.Empty(keys)
+}
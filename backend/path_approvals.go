@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathApprovals(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "approvals/?",
+		HelpSynopsis: "List the transactions waiting for approvals",
+		HelpDescription: `
+
+    LIST - list the IDs of the approval requests
+
+    `,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.listApprovals,
+		},
+	}
+}
+
+func pathApproval(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "approvals/" + framework.GenericNameRegex("id"),
+		HelpSynopsis: "Get, approve or cancel a transaction waiting for approvals",
+		HelpDescription: `
+
+    GET - return the approval request, with the signed transaction once enough approvals were given
+    POST - approve the transaction, as the identity entity of the caller
+    DELETE - cancel the approval request
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"id": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.readApproval,
+			logical.UpdateOperation: b.approve,
+			logical.DeleteOperation: b.deleteApproval,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "config",
+		HelpSynopsis: "Get or update the configuration of the plugin mount",
+		HelpDescription: `
+
+    GET - return the mount configuration
+    POST - update the mount configuration, fields that are omitted keep their current value
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"chainIds": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Chain IDs that transactions can be signed for by any account. An empty list allows any chain.",
+			},
+			"forbidUnprotected": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Refuse to sign legacy transactions without a chain ID, whose signatures can be replayed on any chain.",
+				Default:     false,
+			},
+			"defaultChainId": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Chain ID of the transactions signed without a 'chainId'. 0 signs them without a chain ID (Homestead).",
+			},
+			"defaultGasLimit": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Gas limit of the transactions signed without 'gas'. Defaults to 90000.",
+			},
+			"defaultGasPrice": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Gas price in wei of the legacy and EIP-2930 transactions signed without 'gasPrice'. Defaults to 0.",
+			},
+			"allowExport": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Whether private keys can be exported. Defaults to true.",
+			},
+			"allowImport": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Whether private keys, keystores and mnemonics can be imported. Defaults to true.",
+			},
+			"maxDataSize": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Maximum size in bytes of the transaction data and messages to sign. 0 means no limit.",
+			},
+			"deletedRetention": &framework.FieldSchema{
+				Type:        framework.TypeSignedDurationSecond,
+				Description: "How long deleted accounts can be restored for, for example '72h', before they're purged. Defaults to 7 days.",
+			},
+			"historyRetention": &framework.FieldSchema{
+				Type:        framework.TypeSignedDurationSecond,
+				Description: "How long the signing history of the accounts is kept for, for example '2160h'. 0, the default, keeps it forever.",
+			},
+			"attestationInterval": &framework.FieldSchema{
+				Type:        framework.TypeSignedDurationSecond,
+				Description: "How often the head of the signing log is signed with the attestation key of the mount, for example '24h'. 0, the default, disables the attestations.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.readConfig,
+			logical.UpdateOperation: b.updateConfig,
+		},
+	}
+}
@@ -15,7 +15,7 @@ func pathCreateAndList(b *backend) *framework.Path {
 		HelpSynopsis: "List all the Ethereum accounts maintained by the plugin backend and create new accounts.",
 		HelpDescription: `
 
-    LIST - list all accounts
+    LIST - list all accounts, with their name and metadata, optionally only the ones with the given labels
     POST - create a new account
 
     `,
@@ -25,6 +25,38 @@ func pathCreateAndList(b *backend) *framework.Path {
 				Description: "Hexidecimal string for the private key (32-byte or 64-char long). If present, the request will import the given key instead of generating a new key.",
 				Default:     "",
 			},
+			"keystore": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Web3 Secret Storage (keystore V3) JSON, encrypted with the scrypt or pbkdf2 KDF. If present, the request will import the key decrypted with 'password' instead of generating a new key.",
+				Default:     "",
+			},
+			"wrappedKey": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Base64 encoded private key wrapped for the mount, as the RSA-OAEP (SHA-256) ciphertext of an ephemeral AES-256 key, encrypted to the public key returned by 'wrapping_key', followed by the 32 bytes of the private key wrapped with the AES key using AES-KWP (RFC 5649). If present, the request will import the unwrapped key instead of generating a new key.",
+				Default:     "",
+			},
+			"password": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) The password to decrypt the 'keystore'.",
+				Default:     "",
+			},
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Human-readable name for the account, unique within the mount. The account can be referenced by its name anywhere an address is accepted.",
+				Default:     "",
+			},
+			"exportable": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "(optional, default: false for generated keys, true for imported keys) Whether the private key can be exported. It can be turned off later, but never back on.",
+			},
+			"labels": &framework.FieldSchema{
+				Type:        framework.TypeKVPairs,
+				Description: "(optional) Free-form labels of the account, as key=value pairs. On LIST, only the accounts that have all of these labels are returned.",
+			},
+			"description": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Free-form description of the account.",
+			},
 		},
 	}
 }
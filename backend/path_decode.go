@@ -0,0 +1,29 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathDecode(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "decode",
+		HelpSynopsis: "Preview the transaction that is going to be signed.",
+		HelpDescription: `
+
+    Decode a transaction, given either by the same fields as the sign endpoint or as a raw 'transaction',
+    and return what is going to be signed: the type, chain ID, nonce, fees, destination, value, calldata
+    selector and the signing hash. The sender is recovered for signed transactions.
+
+    `,
+		Fields: transactionFields(map[string]*framework.FieldSchema{
+			"transaction": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Hexidecimal encoded transaction, either legacy RLP or EIP-2718 typed, signed or unsigned. If present, the other transaction fields are ignored.",
+			},
+		}),
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.decodeTransaction,
+		},
+	}
+}
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathDeletedAccounts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "deleted/?",
+		HelpSynopsis: "List the deleted Ethereum accounts that can still be restored",
+		HelpDescription: `
+
+    LIST - list the addresses of the deleted accounts
+
+    `,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.listDeletedAccounts,
+		},
+	}
+}
+
+func pathDeletedAccount(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "deleted/" + framework.GenericNameRegex("address"),
+		HelpSynopsis: "Get a deleted Ethereum account by address",
+		HelpDescription: `
+
+    GET - return when the account was deleted, and when it will be purged
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"address": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.readDeletedAccount,
+		},
+	}
+}
+
+func pathRestoreAccount(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "deleted/" + framework.GenericNameRegex("address") + "/restore",
+		HelpSynopsis: "Restore a deleted Ethereum account",
+		HelpDescription: `
+
+    POST - restore the account, with its name if it's still available
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"address": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.restoreAccount,
+		},
+	}
+}
+
+func pathPurgeAccount(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "deleted/" + framework.GenericNameRegex("address") + "/purge",
+		HelpSynopsis: "Permanently destroy a deleted Ethereum account",
+		HelpDescription: `
+
+    POST - destroy the private key of the deleted account, before the end of the retention period
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"address": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.purgeAccount,
+		},
+	}
+}
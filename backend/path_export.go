@@ -12,14 +12,30 @@ func pathExport(b *backend) *framework.Path {
 		HelpDescription: `
 
     GET - return the account by the name with the private key
+    POST - return the account by the name with the private key in a keystore V3 JSON, encrypted with the given password,
+           or with the private key encrypted to the given RSA or secp256k1 public key
 
     `,
 		Fields: map[string]*framework.FieldSchema{
 			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"password": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The password to encrypt the exported keystore with.",
+			},
+			"publicKey": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The public key to encrypt the exported private key to, instead of a keystore. Either a PEM encoded RSA public key, for RSA-OAEP with SHA-256, or a hex encoded secp256k1 public key, for ECIES.",
+			},
+			"lightKdf": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "(optional, default: false) Use the light scrypt parameters, which are faster but less secure, to encrypt the keystore.",
+				Default:     false,
+			},
 		},
 		ExistenceCheck: b.pathExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
-			logical.ReadOperation: b.exportAccount,
+			logical.ReadOperation:   b.exportAccount,
+			logical.CreateOperation: b.exportAccountEncrypted,
 		},
 	}
 }
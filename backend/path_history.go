@@ -0,0 +1,33 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathHistory(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/history",
+		HelpSynopsis: "Get the transactions signed by an account",
+		HelpDescription: `
+
+    GET - return the transactions signed by the account, oldest first, one page at a time
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"limit": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Maximum number of entries to return.",
+				Default:     DefaultHistoryPageSize,
+			},
+			"after": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Only return the entries after the one with this ID, as returned in 'next' by the previous page.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.readHistory,
+		},
+	}
+}
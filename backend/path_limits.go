@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathLimits(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/limits",
+		HelpSynopsis: "Get the remaining spend allowance of an account",
+		HelpDescription: `
+
+    GET - return the spend limit of the account, the amount spent and remaining in the current window, and when the allowance next increases
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.readLimits,
+		},
+	}
+}
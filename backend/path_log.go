@@ -0,0 +1,28 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathLog(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "log",
+		HelpSynopsis: "Verify the hash-chained signing log of the mount",
+		HelpDescription: `
+
+    GET - return the head of the signing log, after verifying the chain of entries from its base, or from its last
+          attestation, and the last signature of the head by the attestation key of the mount
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"fromAttestation": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Only verify the entries after the last attestation of the head, once its signature is checked. The entries before it are not verified.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.readLog,
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathNonces(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/nonces/" + framework.GenericNameRegex("chainId"),
+		HelpSynopsis: "Get or reset the nonce managed by the plugin for an account on a chain",
+		HelpDescription: `
+
+    GET - return the next nonce of the account on the chain, and the released nonces that are handed out first
+    POST - reset the next nonce, for example to resynchronize with the chain
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name":    &framework.FieldSchema{Type: framework.TypeString},
+			"chainId": &framework.FieldSchema{Type: framework.TypeString},
+			"next": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The nonce of the next transaction of the account on the chain. Required to reset it.",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.readNonces,
+			logical.CreateOperation: b.resetNonces,
+		},
+	}
+}
+
+func pathReleaseNonce(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/nonces/" + framework.GenericNameRegex("chainId") + "/release",
+		HelpSynopsis: "Release a nonce allocated by the plugin to a transaction that was never broadcast",
+		HelpDescription: `
+
+    POST - make the nonce available again, it is allocated to the next transaction signed by the account on the chain
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name":    &framework.FieldSchema{Type: framework.TypeString},
+			"chainId": &framework.FieldSchema{Type: framework.TypeString},
+			"nonce": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The nonce to release.",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.releaseNonceRequest,
+		},
+	}
+}
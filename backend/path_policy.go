@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathPolicy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/policy",
+		HelpSynopsis: "Get, set or remove the signing policy of an account",
+		HelpDescription: `
+
+    GET - return the signing policy of the account
+    POST - set the signing policy, fields that are omitted keep their current value
+    DELETE - remove the signing policy, allowing any transaction to be signed
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"allowedTo": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Addresses the account is allowed to send transactions to, on top of the contracts in 'contracts'. An empty list allows any destination.",
+			},
+			"maxValue": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Maximum value in wei of a single transaction. An empty value removes the limit.",
+			},
+			"maxGasPrice": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Maximum gas price in wei, applied to 'maxFeePerGas' for EIP-1559 transactions. An empty value removes the limit.",
+			},
+			"maxPriorityFeePerGas": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Maximum priority fee in wei of EIP-1559 transactions. An empty value removes the limit.",
+			},
+			"allowContractCreation": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Whether the account is allowed to sign contract deployments. They are allowed until this is set to false.",
+			},
+			"chainIds": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Chain IDs the account can sign transactions for. An empty list allows any chain allowed by the mount configuration.",
+			},
+			"forbidUnprotected": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Refuse to sign legacy transactions without a chain ID, whose signatures can be replayed on any chain.",
+				Default:     false,
+			},
+			"contracts": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "Calls allowed on contracts, keyed by contract address. Each entry holds the 'abi' of the contract and the allowed 'methods', by name, signature or selector, with optional 'in', 'min' and 'max' constraints on their arguments.",
+			},
+			"spendLimit": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Maximum total value in wei the account can sign over the spend window. An empty value removes the limit.",
+			},
+			"spendWindow": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Description: "Length of the rolling window of the spend limit, for example '24h' or '3600'. Defaults to 24 hours.",
+			},
+			"requiredApprovals": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "Number of approvals needed before a transaction is signed. 0 signs transactions right away.",
+			},
+			"approvers": &framework.FieldSchema{
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Vault identity entity IDs allowed to approve transactions. An empty list allows any entity with access to the approvals.",
+			},
+			"approvalTtl": &framework.FieldSchema{
+				Type:        framework.TypeDurationSecond,
+				Description: "How long approval requests stay open, for example '1h'. Defaults to 24 hours.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.readPolicy,
+			logical.UpdateOperation: b.updatePolicy,
+			logical.DeleteOperation: b.deletePolicy,
+		},
+	}
+}
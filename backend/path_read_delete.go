@@ -8,20 +8,41 @@ import (
 func pathReadAndDelete(b *backend) *framework.Path {
 	return &framework.Path{
 		Pattern:      "accounts/" + framework.GenericNameRegex("name"),
-		HelpSynopsis: "Create, get or delete an Ethereum account by name",
+		HelpSynopsis: "Get, update or delete an Ethereum account by address or name",
 		HelpDescription: `
 
-    POST - create a new account for the given name
-    GET - return the account by the name
-    DELETE - deletes the account by the name
+    GET - return the account by the address or name
+    POST - update the account, for example to rename it, to set its labels or to have the plugin manage its nonces
+    DELETE - deletes the account by the address or name, it can be restored until the end of the retention period
 
     `,
 		Fields: map[string]*framework.FieldSchema{
 			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"newName": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The new human-readable name for the account. An empty value removes the current name.",
+			},
+			"managedNonces": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Whether the plugin allocates the nonces of the transactions signed by the account, per chain, instead of the callers.",
+			},
+			"exportable": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "Set to false to prevent the private key from being exported. Accounts that are not exportable cannot be made exportable.",
+			},
+			"labels": &framework.FieldSchema{
+				Type:        framework.TypeKVPairs,
+				Description: "Free-form labels of the account, as key=value pairs. Replaces the current labels, an empty map removes them.",
+			},
+			"description": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Free-form description of the account. An empty value removes the current description.",
+			},
 		},
-		ExistenceCheck: b.pathExistenceCheck,
+		ExistenceCheck: b.accountExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.ReadOperation:   b.readAccount,
+			logical.UpdateOperation: b.updateAccount,
 			logical.DeleteOperation: b.deleteAccount,
 		},
 	}
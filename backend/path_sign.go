@@ -14,48 +14,71 @@ func pathSign(b *backend) *framework.Path {
     Sign a transaction object with properties conforming to the Ethereum JSON-RPC documentation.
 
     `,
-		Fields: map[string]*framework.FieldSchema{
+		Fields: transactionFields(map[string]*framework.FieldSchema{
 			"name": &framework.FieldSchema{Type: framework.TypeString},
-			"to": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "(optional when creating new contract) The contract address the transaction is directed to.",
-				Default:     "",
-			},
-			"data": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "The compiled code of a contract OR the hash of the invoked method signature and encoded parameters.",
-			},
-			"input": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "The compiled code of a contract OR the hash of the invoked method signature and encoded parameters.",
-			},
-			"value": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "(optional) Integer of the value sent with this transaction (in wei).",
-			},
-			"nonce": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "The transaction nonce.",
-			},
-			"gas": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "(optional, default: 90000) Integer of the gas provided for the transaction execution. It will return unused gas",
-				Default:     "90000",
-			},
-			"gasPrice": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "(optional, default: 0) The gas price for the transaction in wei.",
-				Default:     "0",
-			},
-			"chainId": &framework.FieldSchema{
-				Type:        framework.TypeString,
-				Description: "(optional) Chain ID of the target blockchain network. If present, EIP155 signer will be used to sign. If omitted, Homestead signer will be used.",
-				Default:     "0",
-			},
-		},
+		}),
 		ExistenceCheck: b.pathExistenceCheck,
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.CreateOperation: b.signTx,
 		},
 	}
 }
+
+// transactionFields adds the fields describing a transaction, as accepted by the sign endpoint,
+// to the other fields of a path
+func transactionFields(fields map[string]*framework.FieldSchema) map[string]*framework.FieldSchema {
+	for name, schema := range map[string]*framework.FieldSchema{
+		"to": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional when creating new contract) The contract address the transaction is directed to.",
+			Default:     "",
+		},
+		"data": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "The compiled code of a contract OR the hash of the invoked method signature and encoded parameters.",
+		},
+		"input": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "The compiled code of a contract OR the hash of the invoked method signature and encoded parameters.",
+		},
+		"value": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional) Integer of the value sent with this transaction (in wei).",
+		},
+		"nonce": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "The transaction nonce.",
+		},
+		"gas": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional, default: 90000 or the mount default) Integer of the gas provided for the transaction execution. It will return unused gas",
+		},
+		"gasPrice": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional, default: 0 or the mount default) The gas price for the transaction in wei.",
+		},
+		"maxFeePerGas": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional) The maximum total fee per gas the sender is willing to pay in wei (EIP-1559). If present, a dynamic fee transaction will be signed.",
+		},
+		"maxPriorityFeePerGas": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional) The maximum fee per gas to give to the block producer in wei (EIP-1559). If present, a dynamic fee transaction will be signed.",
+		},
+		"accessList": &framework.FieldSchema{
+			Type:        framework.TypeSlice,
+			Description: "(optional) EIP-2930 access list, as an array of objects with 'address' and 'storageKeys' properties. If present without the EIP-1559 fee fields, an access list transaction will be signed.",
+		},
+		"type": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional) The transaction type: 0x0 for legacy transactions, 0x1 for EIP-2930 access list transactions, 0x2 for EIP-1559 dynamic fee transactions. If omitted, the type is inferred from the fee and access list fields.",
+		},
+		"chainId": &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "(optional) Chain ID of the target blockchain network. If present, EIP155 signer will be used to sign. If omitted, the default chain ID of the mount is used, or Homestead signer if there's none. Required for EIP-2930 and EIP-1559 transactions.",
+		},
+	} {
+		fields[name] = schema
+	}
+	return fields
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathSignBatch(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/sign-batch",
+		HelpSynopsis: "Sign several transaction objects in one request.",
+		HelpDescription: `
+
+    Sign an array of transaction objects with the account, each with the same properties as the sign endpoint.
+    Returns one result per transaction, in the same order: either the same data as the sign endpoint, or the
+    'error' that prevented the transaction from being signed. A failed transaction doesn't fail the batch.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"transactions": &framework.FieldSchema{
+				Type:        framework.TypeSlice,
+				Description: "Array of transaction objects, with the same properties as the sign endpoint. At most 1000 transactions can be signed in one batch.",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.signBatch,
+		},
+	}
+}
@@ -0,0 +1,36 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathSignMessage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/sign-message",
+		HelpSynopsis: "Sign an arbitrary message with the EIP-191 personal message prefix.",
+		HelpDescription: `
+
+    Sign a message the same way as the "personal_sign" Ethereum JSON-RPC method, by hashing
+    "\x19Ethereum Signed Message:\n" + len(message) + message. Returns the 65-byte signature
+    in the r || s || v format, where v is 27 or 28.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"message": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The message to sign.",
+			},
+			"encoding": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional, default: utf8) The encoding of the message, either 'utf8' for plain text or 'hex' for hexidecimal encoded bytes.",
+				Default:     "utf8",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.signMessage,
+		},
+	}
+}
@@ -0,0 +1,31 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathSignRawTx(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/sign-raw-tx",
+		HelpSynopsis: "Sign a pre-built unsigned transaction.",
+		HelpDescription: `
+
+    Sign an unsigned transaction serialized by tools such as Foundry, ethers or Hardhat, either legacy RLP
+    or EIP-2718 typed, with the signer matching its type and chain ID. The same checks as the sign endpoint
+    apply. Returns the signed transaction in the same encoding.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"transaction": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Hexidecimal encoded unsigned transaction, with or without zero signature values. Legacy transactions without a chain ID are signed for the default chain ID of the mount.",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.signRawTx,
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathSignTypedData(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "accounts/" + framework.GenericNameRegex("name") + "/sign-typed-data",
+		HelpSynopsis: "Sign EIP-712 typed structured data.",
+		HelpDescription: `
+
+    Sign typed structured data conforming to EIP-712, the same way as the "eth_signTypedData_v4"
+    Ethereum JSON-RPC method. Returns the 65-byte signature in the r || s || v format, where v is
+    27 or 28, along with the digest that was signed.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"types": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "The type definitions, including the EIP712Domain type, keyed by type name.",
+			},
+			"primaryType": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The name of the type of the message.",
+			},
+			"domain": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "The domain separator values, conforming to the EIP712Domain type.",
+			},
+			"message": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "The message to sign, conforming to the primary type.",
+			},
+		},
+		ExistenceCheck: b.pathExistenceCheck,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.CreateOperation: b.signTypedData,
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "verify",
+		HelpSynopsis: "Verify a signature and recover the signing address.",
+		HelpDescription: `
+
+    Recover the address that signed an EIP-191 message, EIP-712 typed data or a signed
+    transaction, and report whether it matches an account held by the plugin.
+
+    Exactly one of 'message', 'typedData' or 'transaction' must be provided. The 'signature'
+    is required for messages and typed data, signed transactions carry their own signature.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"message": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The message that was signed with the EIP-191 personal message prefix.",
+			},
+			"encoding": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional, default: utf8) The encoding of the message, either 'utf8' for plain text or 'hex' for hexidecimal encoded bytes.",
+				Default:     "utf8",
+			},
+			"typedData": &framework.FieldSchema{
+				Type:        framework.TypeMap,
+				Description: "The EIP-712 typed data that was signed, in the {types, primaryType, domain, message} format.",
+			},
+			"transaction": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "A signed transaction, either RLP encoded (legacy) or as an EIP-2718 typed envelope.",
+			},
+			"signature": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "The 65-byte r || s || v signature in hex. The recovery id v can be 0/1 or 27/28.",
+			},
+			"account": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) The account expected to have produced the signature.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.verifySignature,
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathWallets(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "wallets/?",
+		HelpSynopsis: "List all the HD wallets maintained by the plugin backend and create new wallets.",
+		HelpDescription: `
+
+    LIST - list all HD wallets
+    POST - create a new HD wallet from a generated or imported BIP-39 mnemonic
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "Name of the wallet, unique within the mount.",
+			},
+			"mnemonic": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) BIP-39 mnemonic to import. If omitted, a new mnemonic is generated, which never leaves the vault.",
+				Default:     "",
+			},
+			"passphrase": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) BIP-39 passphrase used with the mnemonic to compute the seed.",
+				Default:     "",
+			},
+			"bitSize": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "(optional, default: 256) Entropy size in bits of a generated mnemonic, 128 for 12 words up to 256 for 24 words.",
+				Default:     256,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation:   b.listWallets,
+			logical.UpdateOperation: b.createWallet,
+		},
+	}
+}
+
+func pathWallet(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "wallets/" + framework.GenericNameRegex("name"),
+		HelpSynopsis: "Get or delete an HD wallet by name",
+		HelpDescription: `
+
+    GET - return the wallet by the name
+    DELETE - deletes the wallet by the name, the accounts derived from it are kept
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.readWallet,
+			logical.DeleteOperation: b.deleteWallet,
+		},
+	}
+}
+
+func pathWalletAccounts(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "wallets/" + framework.GenericNameRegex("name") + "/accounts/?",
+		HelpSynopsis: "List the accounts derived from an HD wallet and derive new accounts.",
+		HelpDescription: `
+
+    LIST - list the addresses of the accounts derived from the wallet
+    POST - derive an account from the wallet, at m/44'/60'/0'/0/<index> or at the given path
+
+    The derived accounts are stored alongside the other accounts, and can be used with all of
+    the /accounts endpoints.
+
+    `,
+		Fields: map[string]*framework.FieldSchema{
+			"name": &framework.FieldSchema{Type: framework.TypeString},
+			"index": &framework.FieldSchema{
+				Type:        framework.TypeInt,
+				Description: "(optional) The address index in the default derivation path m/44'/60'/0'/0/<index>. If both 'index' and 'path' are omitted, the next unused index is used.",
+			},
+			"path": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) The full BIP-32 derivation path, such as m/44'/60'/1'/0/0.",
+			},
+			"accountName": &framework.FieldSchema{
+				Type:        framework.TypeString,
+				Description: "(optional) Human-readable name for the derived account.",
+				Default:     "",
+			},
+			"exportable": &framework.FieldSchema{
+				Type:        framework.TypeBool,
+				Description: "(optional) Whether the private key of the derived account can be exported. It can be turned off later, but never back on.",
+				Default:     false,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation:   b.listWalletAccounts,
+			logical.UpdateOperation: b.deriveWalletAccount,
+		},
+	}
+}
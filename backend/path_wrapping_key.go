@@ -0,0 +1,22 @@
+package backend
+
+import (
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathWrappingKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:      "wrapping_key",
+		HelpSynopsis: "Get the public key to wrap the private keys to import with",
+		HelpDescription: `
+
+    GET - return the RSA-4096 public key of the mount, in PEM format. Keys encrypted to it can be imported
+          by passing them in 'wrappedKey' to the accounts endpoint
+
+    `,
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.readWrappingKey,
+		},
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Policy rule names, returned in the "rule" field of the error response of a rejected transaction
+const (
+	RuleAllowedTo             = "allowed_to"
+	RuleMaxValue              = "max_value"
+	RuleMaxGasPrice           = "max_gas_price"
+	RuleMaxPriorityFeePerGas  = "max_priority_fee_per_gas"
+	RuleAllowContractCreation = "allow_contract_creation"
+	RuleChainIDs              = "chain_ids"
+	RuleForbidUnprotected     = "forbid_unprotected"
+	RuleSpendLimit            = "spend_limit"
+	RuleContractMethods       = "contract_methods"
+	RuleContractArguments     = "contract_arguments"
+	RuleRequiredApprovals     = "required_approvals"
+)
+
+// Policy restricts the transactions that can be signed with an account. Amounts are
+// decimal strings in wei, an empty amount means no limit
+type Policy struct {
+	// lower case 0x addresses, empty means any destination is allowed
+	AllowedTo            []string `json:"allowed_to,omitempty"`
+	MaxValue             string   `json:"max_value,omitempty"`
+	MaxGasPrice          string   `json:"max_gas_price,omitempty"`
+	MaxPriorityFeePerGas string   `json:"max_priority_fee_per_gas,omitempty"`
+	// nil leaves contract deployments unrestricted
+	AllowContractCreation *bool `json:"allow_contract_creation,omitempty"`
+	// decimal chain IDs the account can sign for, empty means any chain
+	ChainIDs          []string `json:"chain_ids,omitempty"`
+	ForbidUnprotected bool     `json:"forbid_unprotected"`
+	// total value in wei that can be signed over a rolling window, in seconds
+	SpendLimit  string `json:"spend_limit,omitempty"`
+	SpendWindow int64  `json:"spend_window,omitempty"`
+	// calls allowed on contracts, keyed by lower case 0x address
+	Contracts map[string]*ContractPolicy `json:"contracts,omitempty"`
+	// number of approvals, from the approver entity IDs if set, needed before a
+	// transaction is signed, and how long in seconds the approval requests stay open
+	RequiredApprovals int      `json:"required_approvals,omitempty"`
+	Approvers         []string `json:"approvers,omitempty"`
+	ApprovalTTL       int64    `json:"approval_ttl,omitempty"`
+}
+
+// allowsContractCreation tells whether the policy lets the account sign contract deployments,
+// which it does unless they were explicitly disallowed
+func (p *Policy) allowsContractCreation() bool {
+	return p.AllowContractCreation == nil || *p.AllowContractCreation
+}
+
+// PolicyViolation is returned when a transaction breaks one of the rules of the account policy
+type PolicyViolation struct {
+	Rule    string
+	Message string
+}
+
+// Error formats the violation as "Policy violation [<rule>]: <message>", so clients can tell
+// which rule rejected the transaction from the error alone
+func (v *PolicyViolation) Error() string {
+	return fmt.Sprintf("Policy violation [%s]: %s", v.Rule, v.Message)
+}
+
+// Response returns the error response for the violation, with the rule in its own field so
+// clients don't have to parse the message. It goes with a permission denied error so the
+// request is answered with a 403
+func (v *PolicyViolation) Response() *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"error": v.Error(),
+			"rule":  v.Rule,
+		},
+	}
+}
+
+// Check returns the first rule of the policy broken by the transaction to be signed for
+// the chain, or nil if the transaction is allowed. A nil policy allows everything
+func (p *Policy) Check(tx *types.Transaction, chainId *big.Int) *PolicyViolation {
+	if p == nil {
+		return nil
+	}
+
+	if violation := checkChainID(chainId, p.ChainIDs, p.ForbidUnprotected, "account policy"); violation != nil {
+		return violation
+	}
+	if tx.To() == nil {
+		if !p.allowsContractCreation() {
+			return &PolicyViolation{RuleAllowContractCreation, "contract creation is not allowed"}
+		}
+	} else if len(p.AllowedTo) > 0 {
+		to := strings.ToLower(tx.To().Hex())
+		if _, isContract := p.Contracts[to]; !isContract && !containsString(p.AllowedTo, to) {
+			return &PolicyViolation{RuleAllowedTo, fmt.Sprintf("destination %s is not allowed", to)}
+		}
+	}
+
+	if tx.To() != nil {
+		to := strings.ToLower(tx.To().Hex())
+		if contract, ok := p.Contracts[to]; ok {
+			if violation := contract.checkCall(to, tx.Data()); violation != nil {
+				return violation
+			}
+		}
+	}
+
+	if exceeds(tx.Value(), p.MaxValue) {
+		return &PolicyViolation{RuleMaxValue, fmt.Sprintf("value %s exceeds the maximum of %s", tx.Value(), p.MaxValue)}
+	}
+	// for EIP-1559 transactions this is the fee cap
+	if exceeds(tx.GasPrice(), p.MaxGasPrice) {
+		return &PolicyViolation{RuleMaxGasPrice, fmt.Sprintf("gas price %s exceeds the maximum of %s", tx.GasPrice(), p.MaxGasPrice)}
+	}
+	if tx.Type() == types.DynamicFeeTxType && exceeds(tx.GasTipCap(), p.MaxPriorityFeePerGas) {
+		return &PolicyViolation{RuleMaxPriorityFeePerGas, fmt.Sprintf("priority fee %s exceeds the maximum of %s", tx.GasTipCap(), p.MaxPriorityFeePerGas)}
+	}
+	return nil
+}
+
+// CheckMessage returns the violation if the account cannot sign personal messages. Signed
+// messages can authorize transfers off-chain, so accounts that require approvals can't sign them
+func (p *Policy) CheckMessage() *PolicyViolation {
+	if p.requiresApproval() {
+		return &PolicyViolation{RuleRequiredApprovals, "messages cannot be signed by accounts that require approvals"}
+	}
+	return nil
+}
+
+// CheckTypedData returns the violation if the account cannot sign the typed data. Like signed
+// messages, typed data such as EIP-2612 permits can authorize transfers off-chain, so accounts
+// that require approvals can't sign it. The chain ID of its domain is checked like the one of
+// transactions. With an allowlist of destinations, the verifying contract must be in it. The
+// contracts with a call policy are not allowed, as their restrictions can't be enforced on the
+// typed data
+func (p *Policy) CheckTypedData(typedData *apitypes.TypedData) *PolicyViolation {
+	if p == nil {
+		return nil
+	}
+	if p.requiresApproval() {
+		return &PolicyViolation{RuleRequiredApprovals, "typed data cannot be signed by accounts that require approvals"}
+	}
+	if violation := checkChainID(typedDataChainID(typedData), p.ChainIDs, p.ForbidUnprotected, "account policy"); violation != nil {
+		return violation
+	}
+	if len(p.AllowedTo) == 0 && len(p.Contracts) == 0 {
+		return nil
+	}
+	contract := typedData.Domain.VerifyingContract
+	if !addressRegexp.MatchString(contract) {
+		if len(p.AllowedTo) == 0 {
+			return nil
+		}
+		return &PolicyViolation{RuleAllowedTo, "typed data without a verifying contract is not allowed"}
+	}
+	contract = "0x" + strings.TrimPrefix(strings.ToLower(contract), "0x")
+	if _, isContract := p.Contracts[contract]; isContract {
+		return &PolicyViolation{RuleContractMethods, fmt.Sprintf("typed data cannot be signed for %s, its calls are restricted by the contract policy", contract)}
+	}
+	if len(p.AllowedTo) > 0 && !containsString(p.AllowedTo, contract) {
+		return &PolicyViolation{RuleAllowedTo, fmt.Sprintf("verifying contract %s is not allowed", contract)}
+	}
+	return nil
+}
+
+// checkChainID makes sure the chain ID is one of the allowed ones. A zero chain ID means the
+// transaction is signed without replay protection, and the signature is valid on any chain
+func checkChainID(chainId *big.Int, chainIds []string, forbidUnprotected bool, source string) *PolicyViolation {
+	if chainId.Sign() == 0 {
+		if forbidUnprotected {
+			return &PolicyViolation{RuleForbidUnprotected, fmt.Sprintf("transactions without a chain ID are forbidden by the %s", source)}
+		}
+		if len(chainIds) > 0 {
+			return &PolicyViolation{RuleChainIDs, fmt.Sprintf("a chain ID is required by the %s", source)}
+		}
+		return nil
+	}
+	if len(chainIds) > 0 && !containsString(chainIds, chainId.String()) {
+		return &PolicyViolation{RuleChainIDs, fmt.Sprintf("chain ID %s is not allowed by the %s", chainId, source)}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// exceeds tells whether the amount is above the limit, an empty limit is no limit
+func exceeds(amount *big.Int, limit string) bool {
+	if limit == "" || amount == nil {
+		return false
+	}
+	max, _ := new(big.Int).SetString(limit, 10)
+	return amount.Cmp(max) > 0
+}
+
+func (b *backend) readPolicy(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, err := b.retrieveAccount(ctx, req, nameOrAddress)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if account.Policy == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: policyData(account.Policy),
+	}, nil
+}
+
+func (b *backend) updatePolicy(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, unlock, err := b.retrieveAccountForUpdate(ctx, req, nameOrAddress)
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+
+	// fields that are not in the request keep their current value
+	policy := &Policy{}
+	if account.Policy != nil {
+		*policy = *account.Policy
+	}
+	if allowedTo, ok := data.GetOk("allowedTo"); ok {
+		policy.AllowedTo = nil
+		for _, address := range allowedTo.([]string) {
+			if !addressRegexp.MatchString(address) {
+				return logical.ErrorResponse("Invalid address %s in 'allowedTo'", address), logical.ErrInvalidRequest
+			}
+			policy.AllowedTo = append(policy.AllowedTo, "0x"+strings.TrimPrefix(strings.ToLower(address), "0x"))
+		}
+	}
+	for field, limit := range map[string]*string{
+		"maxValue":             &policy.MaxValue,
+		"maxGasPrice":          &policy.MaxGasPrice,
+		"maxPriorityFeePerGas": &policy.MaxPriorityFeePerGas,
+		"spendLimit":           &policy.SpendLimit,
+	} {
+		if raw, ok := data.GetOk(field); ok {
+			if *limit, err = parseLimit(field, raw.(string)); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+		}
+	}
+	if allowContractCreation, ok := data.GetOk("allowContractCreation"); ok {
+		allow := allowContractCreation.(bool)
+		policy.AllowContractCreation = &allow
+	}
+	if chainIds, ok := data.GetOk("chainIds"); ok {
+		if policy.ChainIDs, err = chainIDsFromInput(chainIds.([]string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+	if forbidUnprotected, ok := data.GetOk("forbidUnprotected"); ok {
+		policy.ForbidUnprotected = forbidUnprotected.(bool)
+	}
+	if contracts, ok := data.GetOk("contracts"); ok {
+		if policy.Contracts, err = contractsFromInput(contracts.(map[string]interface{})); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+	}
+	if spendWindow, ok := data.GetOk("spendWindow"); ok {
+		if spendWindow.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'spendWindow' value"), logical.ErrInvalidRequest
+		}
+		policy.SpendWindow = int64(spendWindow.(int))
+	}
+
+	if requiredApprovals, ok := data.GetOk("requiredApprovals"); ok {
+		if requiredApprovals.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'requiredApprovals' value"), logical.ErrInvalidRequest
+		}
+		policy.RequiredApprovals = requiredApprovals.(int)
+	}
+	if approvers, ok := data.GetOk("approvers"); ok {
+		policy.Approvers = nil
+		if len(approvers.([]string)) > 0 {
+			policy.Approvers = approvers.([]string)
+		}
+	}
+	if approvalTTL, ok := data.GetOk("approvalTtl"); ok {
+		if approvalTTL.(int) < 0 {
+			return logical.ErrorResponse("Invalid 'approvalTtl' value"), logical.ErrInvalidRequest
+		}
+		policy.ApprovalTTL = int64(approvalTTL.(int))
+	}
+	if len(policy.Approvers) > 0 && len(policy.Approvers) < policy.RequiredApprovals {
+		return logical.ErrorResponse("%d approvals are required but only %d approvers are allowed", policy.RequiredApprovals, len(policy.Approvers)), logical.ErrInvalidRequest
+	}
+
+	account.Policy = policy
+	if err := b.saveAccount(ctx, req, account); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: policyData(policy),
+	}, nil
+}
+
+func (b *backend) deletePolicy(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, unlock, err := b.retrieveAccountForUpdate(ctx, req, nameOrAddress)
+	defer unlock()
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if account.Policy == nil {
+		return nil, nil
+	}
+
+	account.Policy = nil
+	if err := b.saveAccount(ctx, req, account); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// parseLimit normalizes a decimal or 0x hex amount to a decimal string, "" removes the limit
+func parseLimit(field, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	amount, ok := math.ParseBig256(value)
+	if !ok || amount.Sign() < 0 {
+		return "", fmt.Errorf("Invalid '%s' value", field)
+	}
+	return amount.String(), nil
+}
+
+// chainIDsFromInput normalizes decimal or 0x hex chain IDs to decimal strings
+func chainIDsFromInput(values []string) ([]string, error) {
+	var chainIds []string
+	for _, value := range values {
+		chainId, ok := math.ParseBig256(value)
+		if !ok || chainId.Sign() <= 0 {
+			return nil, fmt.Errorf("Invalid chain ID %s", value)
+		}
+		chainIds = append(chainIds, chainId.String())
+	}
+	return chainIds, nil
+}
+
+func policyData(policy *Policy) map[string]interface{} {
+	allowedTo := policy.AllowedTo
+	if allowedTo == nil {
+		allowedTo = []string{}
+	}
+	chainIds := policy.ChainIDs
+	if chainIds == nil {
+		chainIds = []string{}
+	}
+	contracts := policy.Contracts
+	if contracts == nil {
+		contracts = map[string]*ContractPolicy{}
+	}
+	approvers := policy.Approvers
+	if approvers == nil {
+		approvers = []string{}
+	}
+	return map[string]interface{}{
+		"allowed_to":               allowedTo,
+		"max_value":                policy.MaxValue,
+		"max_gas_price":            policy.MaxGasPrice,
+		"max_priority_fee_per_gas": policy.MaxPriorityFeePerGas,
+		"allow_contract_creation":  policy.allowsContractCreation(),
+		"chain_ids":                chainIds,
+		"forbid_unprotected":       policy.ForbidUnprotected,
+		"spend_limit":              policy.SpendLimit,
+		"spend_window":             int64(policy.spendWindow().Seconds()),
+		"contracts":                contracts,
+		"required_approvals":       policy.RequiredApprovals,
+		"approvers":                approvers,
+		"approval_ttl":             int64(policy.approvalTTL().Seconds()),
+	}
+}
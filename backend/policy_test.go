@@ -0,0 +1,265 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const allowedTo = "0xf809410b0d6f047c603deb311979cd413e025a84"
+
+func signTestTx(t *testing.T, b logical.Backend, storage logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign")
+	req.Storage = storage
+	req.Data = data
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	// no policy by default
+	req := logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowedTo":             "0xF809410B0D6F047C603DEB311979CD413E025A84",
+		"maxValue":              "0x3e8",
+		"maxGasPrice":           "20000000000",
+		"allowContractCreation": false,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]string{allowedTo}, resp.Data["allowed_to"])
+	assert.Equal("1000", resp.Data["max_value"])
+	assert.Equal("20000000000", resp.Data["max_gas_price"])
+	assert.Equal("", resp.Data["max_priority_fee_per_gas"])
+	assert.Equal(false, resp.Data["allow_contract_creation"])
+
+	// partial update keeps the other rules
+	req.Data = map[string]interface{}{
+		"maxPriorityFeePerGas": "1000000000",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]string{allowedTo}, resp.Data["allowed_to"])
+	assert.Equal("1000", resp.Data["max_value"])
+	assert.Equal("1000000000", resp.Data["max_priority_fee_per_gas"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Equal("20000000000", resp.Data["max_gas_price"])
+
+	// within the policy
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{
+		"data":     "0x",
+		"to":       allowedTo,
+		"value":    "1000",
+		"gas":      21000,
+		"gasPrice": "20000000000",
+		"chainId":  "12345",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NotEmpty(resp.Data["signed_transaction"])
+
+	resp, _ = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1001", "chainId": "12345"})
+	assert.Equal("Policy violation [max_value]: value 1001 exceeds the maximum of 1000", resp.Data["error"])
+	assert.Equal(RuleMaxValue, resp.Data["rule"])
+
+	violations := []struct {
+		rule string
+		data map[string]interface{}
+	}{
+		{RuleAllowedTo, map[string]interface{}{"data": "0x", "to": "0x0000000000000000000000000000000000000001", "chainId": "12345"}},
+		{RuleMaxValue, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1001", "chainId": "12345"}},
+		{RuleMaxGasPrice, map[string]interface{}{"data": "0x", "to": allowedTo, "gasPrice": "20000000001", "chainId": "12345"}},
+		{RuleMaxGasPrice, map[string]interface{}{"data": "0x", "to": allowedTo, "maxFeePerGas": "30000000000", "maxPriorityFeePerGas": "1", "chainId": "12345"}},
+		{RuleMaxPriorityFeePerGas, map[string]interface{}{"data": "0x", "to": allowedTo, "maxFeePerGas": "2000000000", "maxPriorityFeePerGas": "2000000000", "chainId": "12345"}},
+		{RuleAllowContractCreation, map[string]interface{}{"data": "0x6000", "chainId": "12345"}},
+	}
+	for _, v := range violations {
+		resp, err = signTestTx(t, b, storage, v.data)
+		assert.Equal(logical.ErrPermissionDenied, err, v.rule)
+		assert.Equal(v.rule, resp.Data["rule"])
+		assert.Contains(resp.Data["error"], "Policy violation ["+v.rule+"]: ")
+	}
+
+	// allowing contract creation
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowContractCreation": true,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x6000", "chainId": "12345"})
+	assert.Nil(err)
+
+	// removing the policy
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": "0x0000000000000000000000000000000000000001", "value": "1000000", "chainId": "12345"})
+	assert.Nil(err)
+}
+
+func TestPolicyChainIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"chainIds": "12345",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal([]string{"12345"}, resp.Data["chain_ids"])
+	// contract deployments are only restricted when the policy says so
+	assert.Equal(true, resp.Data["allow_contract_creation"])
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x6000", "chainId": "12345"})
+	assert.Nil(err)
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "12345"})
+	assert.Nil(err)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: chain ID 1 is not allowed by the account policy", resp.Data["error"])
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: a chain ID is required by the account policy", resp.Data["error"])
+
+	req.Data = map[string]interface{}{
+		"chainIds":          "",
+		"forbidUnprotected": true,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [forbid_unprotected]: transactions without a chain ID are forbidden by the account policy", resp.Data["error"])
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+}
+
+func TestPolicyFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Account does not exist", err.Error())
+
+	importTestAccount(t, b, storage)
+
+	req.Data = map[string]interface{}{
+		"allowedTo": "0x1234",
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Invalid address 0x1234 in 'allowedTo'", resp.Error().Error())
+
+	req.Data = map[string]interface{}{
+		"maxValue": "lots",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	assert.Equal(logical.ErrInvalidRequest, err)
+	assert.Equal("Invalid 'maxValue' value", resp.Error().Error())
+}
+
+func TestPolicyConcurrentAccountUpdates(t *testing.T) {
+	assert := assert.New(t)
+
+	b, inmem := getBackend(t)
+	importTestAccount(t, b, inmem)
+	storage := &slowStorage{inmem}
+
+	// the policy and the other settings of the account are updated at the same time, neither
+	// update is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+			req.Storage = storage
+			req.Data = map[string]interface{}{
+				[]string{"maxValue", "maxGasPrice", "maxPriorityFeePerGas", "spendLimit"}[i]: "10",
+			}
+			_, err := b.HandleRequest(context.Background(), req)
+			assert.Nil(err)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+			req.Storage = storage
+			req.Data = map[string]interface{}{
+				"labels": map[string]interface{}{"updated": "true"},
+			}
+			_, err := b.HandleRequest(context.Background(), req)
+			assert.Nil(err)
+		}(i)
+	}
+	wg.Wait()
+
+	req := logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = inmem
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !assert.NotNil(resp) {
+		return
+	}
+	for _, field := range []string{"max_value", "max_gas_price", "max_priority_fee_per_gas", "spend_limit"} {
+		assert.Equal("10", resp.Data[field], field)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress)
+	req.Storage = inmem
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(map[string]string{"updated": "true"}, resp.Data["labels"])
+}
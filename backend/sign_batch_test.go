@@ -0,0 +1,206 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signTestBatch(t *testing.T, b logical.Backend, storage logical.Storage, transactions interface{}) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-batch")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"transactions": transactions,
+	}
+	return b.HandleRequest(context.Background(), req)
+}
+
+func TestSignBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"maxValue": "10",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, err := signTestBatch(t, b, storage, []interface{}{
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "1", "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "2", "chainId": "3", "value": "100"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "3", "gas": "not-a-number"},
+		"not-an-object",
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "4", "chainId": "3"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(2, resp.Data["signed"])
+	assert.Equal(0, resp.Data["pending"])
+	assert.Equal(3, resp.Data["failed"])
+	results := resp.Data["results"].([]map[string]interface{})
+	assert.Equal(5, len(results))
+
+	// each result is the same as signing the transaction alone
+	single, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "1", "chainId": "3"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(single.Data["signed_transaction"], results[0]["signed_transaction"])
+	_, sender := signedSender(t, &logical.Response{Data: results[4]})
+	assert.Equal(testAddress, sender)
+
+	assert.Contains(results[1]["error"], "Policy violation ["+RuleMaxValue+"]")
+	assert.Contains(results[2]["error"], "gas")
+	assert.Equal("Each transaction must be an object", results[3]["error"])
+
+	// every signed transaction is recorded in the history
+	resp, _ = readTestHistory(t, b, storage, nil)
+	assert.Equal(3, len(resp.Data["entries"].([]map[string]interface{})))
+
+	// the command line passes the array as a JSON string
+	resp, err = signTestBatch(t, b, storage, `[{"data":"0x","to":"`+allowedTo+`","nonce":"5","chainId":"3"}]`)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(1, resp.Data["signed"])
+}
+
+func TestSignBatchFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	_, err := signTestBatch(t, b, storage, []interface{}{})
+	assert.Equal("'transactions' is required", err.Error())
+
+	_, err = signTestBatch(t, b, storage, "[not json")
+	assert.Contains(err.Error(), "Invalid 'transactions' value")
+
+	tooMany := make([]interface{}, MaxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": fmt.Sprintf("%d", i)}
+	}
+	_, err = signTestBatch(t, b, storage, tooMany)
+	assert.Equal(fmt.Sprintf("At most %d transactions can be signed in one batch", MaxBatchSize), err.Error())
+
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/0x0000000000000000000000000000000000000001/sign-batch")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"transactions": []interface{}{map[string]interface{}{"data": "0x", "to": allowedTo}},
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Contains(err.Error(), "does not exist")
+
+	// managed nonces are allocated for each transaction
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"managedNonces": true,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := signTestBatch(t, b, storage, []interface{}{
+		map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "3", "nonce": "7"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	results := resp.Data["results"].([]map[string]interface{})
+	assert.Equal(uint64(0), results[0]["nonce"])
+	assert.Equal(uint64(1), results[1]["nonce"])
+	assert.Contains(results[2]["error"], "managed by the plugin")
+}
+
+func TestSignBatchApprovals(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+	requireApprovals(t, b, storage, map[string]interface{}{
+		"requiredApprovals": 1,
+		"approvers":         "entity1",
+	})
+
+	// transactions that only created an approval request are not counted as signed
+	resp, err := signTestBatch(t, b, storage, []interface{}{
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "1", "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "2", "chainId": "3", "value": "0xzz"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "3", "chainId": "3"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(0, resp.Data["signed"])
+	assert.Equal(2, resp.Data["pending"])
+	assert.Equal(1, resp.Data["failed"])
+	results := resp.Data["results"].([]map[string]interface{})
+	assert.Nil(results[0]["signed_transaction"])
+	assert.Equal(ApprovalPending, results[0]["status"])
+	assert.NotNil(results[2]["approval_id"])
+
+	// once approved, the transaction is signed
+	resp, err = approveRequest(t, b, storage, results[0]["approval_id"].(string), "entity1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NotNil(resp.Data["signed_transaction"])
+}
+
+func TestSignBatchInvalidNumbers(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	// a malformed number fails its own item, not the whole batch
+	resp, err := signTestBatch(t, b, storage, []interface{}{
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "1", "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "2", "chainId": "3", "value": "0xzz"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "3", "chainId": "3", "gasPrice": "1e18"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "not-a-number", "chainId": "3"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "4", "chainId": "0x"},
+		map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "5", "chainId": "3"},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(2, resp.Data["signed"])
+	assert.Equal(0, resp.Data["pending"])
+	assert.Equal(4, resp.Data["failed"])
+	results := resp.Data["results"].([]map[string]interface{})
+	assert.Equal(6, len(results))
+	assert.NotNil(results[0]["signed_transaction"])
+	assert.Equal("Invalid amount for the 'value' field", results[1]["error"])
+	assert.Equal("Invalid 'gasPrice' value", results[2]["error"])
+	assert.Equal("Invalid 'nonce' value", results[3]["error"])
+	assert.Contains(results[4]["error"], "chainId")
+	assert.NotNil(results[5]["signed_transaction"])
+}
@@ -0,0 +1,137 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signTestRawTx(t *testing.T, b logical.Backend, storage logical.Storage, transaction string) (*logical.Response, error) {
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-raw-tx")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"transaction": transaction,
+	}
+	return b.HandleRequest(context.Background(), req)
+}
+
+func signedSender(t *testing.T, resp *logical.Response) (*types.Transaction, string) {
+	signedTxBytes, _ := hexutil.Decode(resp.Data["signed_transaction"].(string))
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(signedTxBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return tx, strings.ToLower(sender.Hex())
+}
+
+func TestSignRawTx(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	to := common.HexToAddress(allowedTo)
+	unsigned := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(5),
+		Nonce:     3,
+		GasTipCap: big.NewInt(1000000000),
+		GasFeeCap: big.NewInt(20000000000),
+		Gas:       21000,
+		To:        &to,
+		Value:     big.NewInt(100),
+	})
+	unsignedBytes, _ := unsigned.MarshalBinary()
+	resp, err := signTestRawTx(t, b, storage, hexutil.Encode(unsignedBytes))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tx, sender := signedSender(t, resp)
+	assert.Equal(testAddress, sender)
+	assert.Equal(uint8(types.DynamicFeeTxType), tx.Type())
+	assert.Equal(big.NewInt(5), tx.ChainId())
+	assert.Equal(uint64(3), tx.Nonce())
+	assert.Equal(types.NewLondonSigner(big.NewInt(5)).Hash(unsigned), types.NewLondonSigner(big.NewInt(5)).Hash(tx))
+
+	// unsigned EIP-155 legacy transaction, as serialized by ethers
+	legacy, _ := rlp.EncodeToBytes([]interface{}{uint64(4), big.NewInt(1000), uint64(21000), to, big.NewInt(1), []byte{}, big.NewInt(5), uint64(0), uint64(0)})
+	resp, err = signTestRawTx(t, b, storage, hexutil.Encode(legacy))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	tx, sender = signedSender(t, resp)
+	assert.Equal(testAddress, sender)
+	assert.Equal(uint8(types.LegacyTxType), tx.Type())
+	assert.Equal(big.NewInt(5), tx.ChainId())
+
+	// the policy of the account applies
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"maxValue": "10",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err = signTestRawTx(t, b, storage, hexutil.Encode(unsignedBytes))
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Contains(resp.Data["error"], "["+RuleMaxValue+"]")
+}
+
+func TestSignRawTxFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	_, err := signTestRawTx(t, b, storage, "")
+	assert.Equal("'transaction' is required", err.Error())
+
+	_, err = signTestRawTx(t, b, storage, "0x01c0")
+	assert.Contains(err.Error(), "Failed to decode the transaction")
+
+	signed, err := signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "nonce": "1", "chainId": "3"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = signTestRawTx(t, b, storage, signed.Data["signed_transaction"].(string))
+	assert.Equal("The transaction is already signed", err.Error())
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress)
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"managedNonces": true,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unsigned, _ := types.NewTransaction(0, common.HexToAddress(allowedTo), big.NewInt(0), 21000, big.NewInt(0), nil).MarshalBinary()
+	_, err = signTestRawTx(t, b, storage, hexutil.Encode(unsigned))
+	assert.Equal("Raw transactions cannot be signed by account "+testAddress+", its nonces are managed by the plugin", err.Error())
+}
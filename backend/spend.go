@@ -0,0 +1,205 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// DefaultSpendWindow is the window of a spend limit set without an explicit window
+const DefaultSpendWindow = 24 * time.Hour
+
+// Spends records the value of the transactions signed by an account, within the window
+// of its spend limit
+type Spends struct {
+	Entries []*Spend `json:"entries"`
+}
+
+// Spend is the value in wei of a signed transaction
+type Spend struct {
+	Time   time.Time `json:"time"`
+	Amount string    `json:"amount"`
+}
+
+// window returns the total spent since the start of the window, and the time the
+// oldest spend in the window leaves it
+func (s *Spends) window(start time.Time, window time.Duration) (*big.Int, time.Time) {
+	total := big.NewInt(0)
+	var resetsAt time.Time
+	for _, spend := range s.Entries {
+		if !spend.Time.After(start) {
+			continue
+		}
+		amount, _ := new(big.Int).SetString(spend.Amount, 10)
+		total.Add(total, amount)
+		if resetsAt.IsZero() || spend.Time.Add(window).Before(resetsAt) {
+			resetsAt = spend.Time.Add(window)
+		}
+	}
+	return total, resetsAt
+}
+
+// spendWindow returns the length of the rolling window of the spend limit
+func (p *Policy) spendWindow() time.Duration {
+	if p.SpendWindow > 0 {
+		return time.Duration(p.SpendWindow) * time.Second
+	}
+	return DefaultSpendWindow
+}
+
+// recordSpend adds the value to the spends of the account in the current window, unless
+// it exceeds the spend limit of the account policy, in which case the violation is returned.
+// The recorded spend, if any, is returned for releaseSpend to take it back if the transaction
+// isn't signed after all
+func (b *backend) recordSpend(ctx context.Context, storage logical.Storage, account *Account, value *big.Int) (*Spend, *PolicyViolation, error) {
+	policy := account.Policy
+	if policy == nil || policy.SpendLimit == "" || value.Sign() == 0 {
+		return nil, nil, nil
+	}
+
+	lock := locksutil.LockForKey(b.locks, "spend/"+account.Address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	spends, err := b.retrieveSpends(ctx, storage, account.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	window := policy.spendWindow()
+	spent, _ := spends.window(now.Add(-window), window)
+	limit, _ := new(big.Int).SetString(policy.SpendLimit, 10)
+	remaining := new(big.Int).Sub(limit, spent)
+	if value.Cmp(remaining) > 0 {
+		if remaining.Sign() < 0 {
+			remaining.SetInt64(0)
+		}
+		return nil, &PolicyViolation{RuleSpendLimit, fmt.Sprintf("value %s exceeds the remaining allowance of %s for the last %s", value, remaining, window)}, nil
+	}
+
+	// spends that left the window are no longer needed
+	entries := []*Spend{}
+	for _, spend := range spends.Entries {
+		if spend.Time.After(now.Add(-window)) {
+			entries = append(entries, spend)
+		}
+	}
+	spend := &Spend{Time: now, Amount: value.String()}
+	spends.Entries = append(entries, spend)
+	if err := b.saveSpends(ctx, storage, account.Address, spends); err != nil {
+		return nil, nil, err
+	}
+	return spend, nil, nil
+}
+
+// releaseSpend removes a spend recorded for a transaction that failed to be signed, to give
+// the allowance back
+func (b *backend) releaseSpend(ctx context.Context, storage logical.Storage, address string, spend *Spend) error {
+	lock := locksutil.LockForKey(b.locks, "spend/"+address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	spends, err := b.retrieveSpends(ctx, storage, address)
+	if err != nil {
+		return err
+	}
+	for i, entry := range spends.Entries {
+		if entry.Time.Equal(spend.Time) && entry.Amount == spend.Amount {
+			spends.Entries = append(spends.Entries[:i], spends.Entries[i+1:]...)
+			return b.saveSpends(ctx, storage, address, spends)
+		}
+	}
+	return nil
+}
+
+func (b *backend) saveSpends(ctx context.Context, storage logical.Storage, address string, spends *Spends) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("spend/%s", address), spends)
+	if err != nil {
+		return err
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the spends of the account", "address", address, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (b *backend) readLimits(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	nameOrAddress := data.Get("name").(string)
+	account, err := b.retrieveAccount(ctx, req, nameOrAddress)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Account does not exist")
+	}
+	if account.Policy == nil || account.Policy.SpendLimit == "" {
+		return nil, nil
+	}
+
+	spends, err := b.retrieveSpends(ctx, req.Storage, account.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	window := account.Policy.spendWindow()
+	spent, resetsAt := spends.window(now.Add(-window), window)
+	limit, _ := new(big.Int).SetString(account.Policy.SpendLimit, 10)
+	remaining := new(big.Int).Sub(limit, spent)
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
+	}
+
+	// nothing was spent in the window, the full allowance is available
+	resets := ""
+	if !resetsAt.IsZero() {
+		resets = resetsAt.Format(time.RFC3339)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"spend_limit":  limit.String(),
+			"spend_window": int64(window.Seconds()),
+			"spent":        spent.String(),
+			"remaining":    remaining.String(),
+			"resets_at":    resets,
+		},
+	}, nil
+}
+
+func (b *backend) retrieveSpends(ctx context.Context, storage logical.Storage, address string) (*Spends, error) {
+	entry, err := storage.Get(ctx, fmt.Sprintf("spend/%s", address))
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the spends of the account", "address", address, "error", err)
+		return nil, err
+	}
+	spends := &Spends{}
+	if entry == nil {
+		return spends, nil
+	}
+	if err := entry.DecodeJSON(spends); err != nil {
+		b.Logger().Error("Failed to decode the spends of the account", "address", address, "error", err)
+		return nil, err
+	}
+	return spends, nil
+}
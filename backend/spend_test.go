@@ -0,0 +1,166 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpendLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	// no limit set
+	req := logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/limits")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	assert.Nil(resp)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"spendLimit":            "1000",
+		"spendWindow":           "1h",
+		"allowContractCreation": true,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("1000", resp.Data["spend_limit"])
+	assert.Equal(int64(3600), resp.Data["spend_window"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/limits")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("1000", resp.Data["remaining"])
+	assert.Equal("0", resp.Data["spent"])
+	assert.Equal("", resp.Data["resets_at"])
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "600", "chainId": "1"})
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "400", "chainId": "1"})
+	assert.Nil(err)
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1", "chainId": "1"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [spend_limit]: value 1 exceeds the remaining allowance of 0 for the last 1h0m0s", resp.Data["error"])
+
+	// zero value transactions are not limited
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "chainId": "1"})
+	assert.Nil(err)
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("1000", resp.Data["spent"])
+	assert.Equal("0", resp.Data["remaining"])
+	resetsAt, err := time.Parse(time.RFC3339, resp.Data["resets_at"].(string))
+	assert.Nil(err)
+	assert.WithinDuration(time.Now().Add(time.Hour), resetsAt, time.Minute)
+
+	// spends that left the window no longer count
+	spends, _ := b.(*backend).retrieveSpends(context.Background(), storage, testAddress)
+	spends.Entries[0].Time = time.Now().Add(-2 * time.Hour)
+	entry, _ := logical.StorageEntryJSON("spend/"+testAddress, spends)
+	storage.Put(context.Background(), entry)
+
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("400", resp.Data["spent"])
+	assert.Equal("600", resp.Data["remaining"])
+
+	resp, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "601", "chainId": "1"})
+	assert.Equal(logical.ErrPermissionDenied, err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "600", "chainId": "1"})
+	assert.Nil(err)
+
+	spends, _ = b.(*backend).retrieveSpends(context.Background(), storage, testAddress)
+	assert.Equal(2, len(spends.Entries))
+
+	// removing the limit
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"spendLimit": "",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Nil(err)
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "5000", "chainId": "1"})
+	assert.Nil(err)
+}
+
+// failingStorage fails the writes of the entries under the prefix
+type failingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return fmt.Errorf("Bang for Put!")
+	}
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestSpendReleasedOnFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"spendLimit": "1000",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// the signature can't be recorded, so it is not returned and doesn't consume the allowance
+	failing := &failingStorage{storage, "history/"}
+	_, err := signTestTx(t, b, failing, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "600", "chainId": "1"})
+	assert.Equal("Bang for Put!", err.Error())
+
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/"+testAddress+"/limits")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0", resp.Data["spent"])
+	assert.Equal("1000", resp.Data["remaining"])
+
+	_, err = signTestTx(t, b, storage, map[string]interface{}{"data": "0x", "to": allowedTo, "value": "1000", "chainId": "1"})
+	assert.Nil(err)
+}
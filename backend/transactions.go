@@ -0,0 +1,454 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// transactionInput holds the validated fields of a transaction to sign, before the defaults
+// of the mount configuration are applied. Optional fields that were omitted are nil
+type transactionInput struct {
+	txType     uint8
+	data       []byte
+	to         *common.Address
+	value      *big.Int
+	nonce      uint64
+	gas        *big.Int
+	gasPrice   *big.Int
+	gasFeeCap  *big.Int
+	gasTipCap  *big.Int
+	chainId    *big.Int
+	accessList types.AccessList
+}
+
+// transactionInputFromFields validates the fields of the request describing a transaction
+func (b *backend) transactionInputFromFields(data *framework.FieldData) (*transactionInput, error) {
+	dataInput := data.Get("data").(string)
+	// some client such as go-ethereum uses "input" instead of "data"
+	if dataInput == "" {
+		dataInput = data.Get("input").(string)
+	}
+	if len(dataInput) > 2 && dataInput[0:2] != "0x" {
+		dataInput = "0x" + dataInput
+	}
+
+	txDataToSign, err := hexutil.Decode(dataInput)
+	if err != nil {
+		b.Logger().Error("Failed to decode payload for the 'data' field", "error", err)
+		return nil, err
+	}
+	input := &transactionInput{data: txDataToSign}
+
+	if input.value, err = ValidNumber(data.Get("value").(string)); err != nil {
+		b.Logger().Error("Invalid amount for the 'value' field", "value", data.Get("value").(string))
+		return nil, fmt.Errorf("Invalid amount for the 'value' field")
+	}
+
+	if rawChainId, ok := data.GetOk("chainId"); ok {
+		if input.chainId, err = ValidNumber(rawChainId.(string)); err != nil {
+			b.Logger().Error("Invalid chainId", "chainId", rawChainId.(string))
+			return nil, fmt.Errorf("Invalid 'chainId' value")
+		}
+	}
+
+	if rawGas, ok := data.GetOk("gas"); ok {
+		if input.gas, err = ValidNumber(rawGas.(string)); err != nil || !input.gas.IsUint64() {
+			b.Logger().Error("Invalid gas limit", "gas", rawGas.(string))
+			return nil, fmt.Errorf("Invalid gas limit")
+		}
+	}
+
+	if rawGasPrice, ok := data.GetOk("gasPrice"); ok {
+		if input.gasPrice, err = ValidNumber(rawGasPrice.(string)); err != nil {
+			b.Logger().Error("Invalid gas price", "gasPrice", rawGasPrice.(string))
+			return nil, fmt.Errorf("Invalid 'gasPrice' value")
+		}
+	}
+
+	nonceIn, err := ValidNumber(data.Get("nonce").(string))
+	if err != nil || !nonceIn.IsUint64() {
+		b.Logger().Error("Invalid nonce", "nonce", data.Get("nonce").(string))
+		return nil, fmt.Errorf("Invalid 'nonce' value")
+	}
+	input.nonce = nonceIn.Uint64()
+
+	input.txType, err = transactionType(data)
+	if err != nil {
+		b.Logger().Error("Invalid transaction type", "type", data.Get("type").(string))
+		return nil, err
+	}
+
+	input.accessList, err = accessListFromInput(data)
+	if err != nil {
+		b.Logger().Error("Invalid access list", "error", err)
+		return nil, err
+	}
+	if input.accessList != nil && input.txType == types.LegacyTxType {
+		return nil, fmt.Errorf("'accessList' cannot be used with legacy transactions")
+	}
+
+	if rawAddressTo := data.Get("to").(string); rawAddressTo != "" {
+		address := common.HexToAddress(rawAddressTo)
+		input.to = &address
+	}
+
+	_, hasFeeCap := data.GetOk("maxFeePerGas")
+	_, hasTipCap := data.GetOk("maxPriorityFeePerGas")
+	if (hasFeeCap || hasTipCap) && input.txType != types.DynamicFeeTxType {
+		return nil, fmt.Errorf("'maxFeePerGas' and 'maxPriorityFeePerGas' can only be used with EIP-1559 transactions")
+	}
+	if input.txType == types.DynamicFeeTxType {
+		if _, ok := data.GetOk("gasPrice"); ok {
+			return nil, fmt.Errorf("'gasPrice' cannot be used with EIP-1559 transactions, use 'maxFeePerGas' and 'maxPriorityFeePerGas' instead")
+		}
+		input.gasFeeCap, input.gasTipCap, err = dynamicFees(data)
+		if err != nil {
+			b.Logger().Error("Invalid EIP-1559 fee values", "maxFeePerGas", data.Get("maxFeePerGas").(string), "maxPriorityFeePerGas", data.Get("maxPriorityFeePerGas").(string))
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// transaction builds the unsigned transaction, with the defaults of the mount configuration
+// for the omitted fields, and returns it together with the chain ID it is going to be signed for
+func (input *transactionInput) transaction(config *Config) (*types.Transaction, *big.Int, error) {
+	if err := config.checkDataSize("data", len(input.data)); err != nil {
+		return nil, nil, err
+	}
+
+	chainId := input.chainId
+	if chainId == nil {
+		chainId, _ = new(big.Int).SetString(config.DefaultChainID, 10)
+	}
+	gasLimit := config.DefaultGasLimit
+	if input.gas != nil {
+		gasLimit = input.gas.Uint64()
+	}
+	gasPrice := input.gasPrice
+	if gasPrice == nil {
+		gasPrice, _ = new(big.Int).SetString(config.DefaultGasPrice, 10)
+	}
+
+	var tx *types.Transaction
+	switch input.txType {
+	case types.DynamicFeeTxType:
+		if big.NewInt(0).Cmp(chainId) == 0 {
+			return nil, nil, fmt.Errorf("'chainId' is required for EIP-1559 transactions")
+		}
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:    chainId,
+			Nonce:      input.nonce,
+			GasTipCap:  input.gasTipCap,
+			GasFeeCap:  input.gasFeeCap,
+			Gas:        gasLimit,
+			To:         input.to,
+			Value:      input.value,
+			Data:       input.data,
+			AccessList: input.accessList,
+		})
+	case types.AccessListTxType:
+		if big.NewInt(0).Cmp(chainId) == 0 {
+			return nil, nil, fmt.Errorf("'chainId' is required for EIP-2930 transactions")
+		}
+		tx = types.NewTx(&types.AccessListTx{
+			ChainID:    chainId,
+			Nonce:      input.nonce,
+			GasPrice:   gasPrice,
+			Gas:        gasLimit,
+			To:         input.to,
+			Value:      input.value,
+			Data:       input.data,
+			AccessList: input.accessList,
+		})
+	default:
+		if input.to == nil {
+			tx = types.NewContractCreation(input.nonce, input.value, gasLimit, gasPrice, input.data)
+		} else {
+			tx = types.NewTransaction(input.nonce, *input.to, input.value, gasLimit, gasPrice, input.data)
+		}
+	}
+	return tx, chainId, nil
+}
+
+// signTransaction checks the transaction against the mount configuration and the policy of
+// the account, then signs it with the account key and returns the encoded result. When the
+// policy requires approvals, a pending approval request is returned instead
+func (b *backend) signTransaction(ctx context.Context, req *logical.Request, account *Account, privateKey *ecdsa.PrivateKey, tx *types.Transaction, chainId *big.Int) (*logical.Response, error) {
+	violation, err := b.checkTransaction(ctx, req.Storage, account, tx, chainId)
+	if err != nil {
+		return nil, err
+	}
+	if violation != nil {
+		return violation.Response(), logical.ErrPermissionDenied
+	}
+
+	if account.Policy.requiresApproval() {
+		return b.requestApproval(ctx, req, account, tx, chainId)
+	}
+
+	signedTx, violation, err := b.finalizeTransaction(ctx, req.Storage, account, privateKey, tx, chainId, req.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	if violation != nil {
+		return violation.Response(), logical.ErrPermissionDenied
+	}
+	resp, err := transactionResponse(signedTx)
+	if err != nil {
+		return nil, err
+	}
+	if account.ManagedNonces {
+		resp.Data["nonce"] = signedTx.Nonce()
+	}
+	return resp, nil
+}
+
+// checkTransaction returns the violation if the transaction cannot be signed for the chain
+// under the mount configuration or the policy of the account
+func (b *backend) checkTransaction(ctx context.Context, storage logical.Storage, account *Account, tx *types.Transaction, chainId *big.Int) (*PolicyViolation, error) {
+	config, err := b.retrieveConfig(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	violation := config.Check(chainId)
+	if violation == nil {
+		violation = account.Policy.Check(tx, chainId)
+	}
+	if violation != nil {
+		b.Logger().Warn("Transaction rejected by the signing policy", "address", account.Address, "rule", violation.Rule, "error", violation.Message)
+	}
+	return violation, nil
+}
+
+// finalizeTransaction counts the transaction against the spend limit of the account, signs it
+// and records it in the signing history of the account
+func (b *backend) finalizeTransaction(ctx context.Context, storage logical.Storage, account *Account, privateKey *ecdsa.PrivateKey, tx *types.Transaction, chainId *big.Int, requestedBy string) (*types.Transaction, *PolicyViolation, error) {
+	spend, violation, err := b.recordSpend(ctx, storage, account, tx.Value())
+	if err != nil {
+		return nil, nil, err
+	}
+	if violation != nil {
+		b.Logger().Warn("Transaction rejected by the spend limit", "address", account.Address, "value", tx.Value())
+		return nil, violation, nil
+	}
+
+	signedTx, err := b.signAndRecord(ctx, storage, account, privateKey, tx, chainId, requestedBy)
+	if err != nil {
+		// the allowance is only consumed by the transactions actually signed
+		if spend != nil {
+			if releaseErr := b.releaseSpend(ctx, storage, account.Address, spend); releaseErr != nil {
+				b.Logger().Error("Failed to release the spend of the transaction", "value", spend.Amount, "error", releaseErr)
+			}
+		}
+		return nil, nil, err
+	}
+	return signedTx, nil, nil
+}
+
+// signAndRecord allocates the nonce of the transaction if the account has managed nonces,
+// signs it and records it in the signing history
+func (b *backend) signAndRecord(ctx context.Context, storage logical.Storage, account *Account, privateKey *ecdsa.PrivateKey, tx *types.Transaction, chainId *big.Int, requestedBy string) (*types.Transaction, error) {
+	if account.ManagedNonces {
+		nonce, err := b.allocateNonce(ctx, storage, account.Address, chainId)
+		if err != nil {
+			return nil, err
+		}
+		tx = withNonce(tx, nonce)
+	}
+
+	signedTx, err := types.SignTx(tx, transactionSigner(tx.Type(), chainId), privateKey)
+	if err != nil {
+		b.Logger().Error("Failed to sign the transaction object", "error", err)
+	} else {
+		// a signature that can't be recorded is not returned
+		err = b.recordHistory(ctx, storage, account, signedTx, chainId, requestedBy)
+	}
+	if err != nil {
+		if account.ManagedNonces {
+			if _, releaseErr := b.releaseNonce(ctx, storage, account.Address, chainId, tx.Nonce()); releaseErr != nil {
+				b.Logger().Error("Failed to release the nonce of the transaction", "nonce", tx.Nonce(), "error", releaseErr)
+			}
+		}
+		return nil, err
+	}
+	return signedTx, nil
+}
+
+// withNonce returns a copy of the unsigned transaction with the given nonce
+func withNonce(tx *types.Transaction, nonce uint64) *types.Transaction {
+	switch tx.Type() {
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      nonce,
+			GasTipCap:  tx.GasTipCap(),
+			GasFeeCap:  tx.GasFeeCap(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	case types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      nonce,
+			GasPrice:   tx.GasPrice(),
+			Gas:        tx.Gas(),
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: tx.AccessList(),
+		})
+	default:
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: tx.GasPrice(),
+			Gas:      tx.Gas(),
+			To:       tx.To(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		})
+	}
+}
+
+func transactionResponse(signedTx *types.Transaction) (*logical.Response, error) {
+	// legacy transactions are encoded as plain RLP, typed transactions
+	// use the EIP-2718 envelope (type byte || RLP payload)
+	signedTxBytes, err := signedTx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"transaction_hash":   signedTx.Hash().Hex(),
+			"signed_transaction": hexutil.Encode(signedTxBytes),
+		},
+	}, nil
+}
+
+// transactionSigner returns the signer matching the type of the transaction, legacy
+// transactions without a chain ID are signed with the pre-EIP-155 (Homestead) scheme
+func transactionSigner(txType uint8, chainId *big.Int) types.Signer {
+	switch txType {
+	case types.DynamicFeeTxType:
+		return types.NewLondonSigner(chainId)
+	case types.AccessListTxType:
+		return types.NewEIP2930Signer(chainId)
+	default:
+		if big.NewInt(0).Cmp(chainId) == 0 {
+			return types.HomesteadSigner{}
+		}
+		return types.NewEIP155Signer(chainId)
+	}
+}
+
+// transactionType returns the EIP-2718 type of the transaction to be signed, either
+// from the explicit "type" field or inferred from the fee fields that were supplied
+func transactionType(data *framework.FieldData) (uint8, error) {
+	if rawType, ok := data.GetOk("type"); ok && rawType.(string) != "" {
+		txType, err := ValidNumber(rawType.(string))
+		if err != nil || !txType.IsUint64() {
+			return 0, fmt.Errorf("Invalid 'type' value")
+		}
+		switch txType.Uint64() {
+		case types.LegacyTxType, types.AccessListTxType, types.DynamicFeeTxType:
+			return uint8(txType.Uint64()), nil
+		default:
+			return 0, fmt.Errorf("Unsupported transaction type %s", rawType.(string))
+		}
+	}
+	_, hasFeeCap := data.GetOk("maxFeePerGas")
+	_, hasTipCap := data.GetOk("maxPriorityFeePerGas")
+	if hasFeeCap || hasTipCap {
+		return types.DynamicFeeTxType, nil
+	}
+	if _, ok := data.GetOk("accessList"); ok {
+		return types.AccessListTxType, nil
+	}
+	return types.LegacyTxType, nil
+}
+
+// jsonListInput returns the items of a list field, which the command line passes as a single
+// JSON string holding the whole list
+func jsonListInput(field string, items []interface{}) ([]interface{}, error) {
+	if len(items) != 1 {
+		return items, nil
+	}
+	s, ok := items[0].(string)
+	if !ok {
+		return items, nil
+	}
+	var decoded []interface{}
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return nil, fmt.Errorf("Invalid '%s' value: %v", field, err)
+	}
+	return decoded, nil
+}
+
+// accessListFromInput parses the optional "accessList" field, which follows the
+// JSON-RPC shape: [{"address": "0x...", "storageKeys": ["0x...", ...]}, ...]
+func accessListFromInput(data *framework.FieldData) (types.AccessList, error) {
+	raw, ok := data.GetOk("accessList")
+	if !ok {
+		return nil, nil
+	}
+	items, err := jsonListInput("accessList", raw.([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid 'accessList' value: %v", err)
+	}
+	accessList := types.AccessList{}
+	if err := json.Unmarshal(encoded, &accessList); err != nil {
+		return nil, fmt.Errorf("Invalid 'accessList' value: %v", err)
+	}
+	return accessList, nil
+}
+
+// dynamicFees returns the fee cap and tip cap of an EIP-1559 transaction
+func dynamicFees(data *framework.FieldData) (*big.Int, *big.Int, error) {
+	rawFeeCap, ok := data.GetOk("maxFeePerGas")
+	if !ok {
+		return nil, nil, fmt.Errorf("'maxFeePerGas' is required for EIP-1559 transactions")
+	}
+	gasFeeCap, err := ValidNumber(rawFeeCap.(string))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid 'maxFeePerGas' value")
+	}
+	gasTipCap, err := ValidNumber(data.Get("maxPriorityFeePerGas").(string))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid 'maxPriorityFeePerGas' value")
+	}
+	if gasTipCap.Cmp(gasFeeCap) > 0 {
+		return nil, nil, fmt.Errorf("'maxPriorityFeePerGas' cannot be higher than 'maxFeePerGas'")
+	}
+	return gasFeeCap, gasTipCap, nil
+}
@@ -0,0 +1,176 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) signTypedData(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	from := data.Get("name").(string)
+
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	typedData, err := typedDataFromInput(data, config)
+	if err != nil {
+		b.Logger().Error("Failed to parse the typed data", "error", err)
+		return nil, err
+	}
+	digest, domainSeparator, err := typedDataHash(typedData)
+	if err != nil {
+		b.Logger().Error("Failed to hash the typed data", "error", err)
+		return nil, err
+	}
+
+	account, err := b.retrieveAccount(ctx, req, from)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the signing account", "address", from, "error", err)
+		return nil, fmt.Errorf("Error retrieving signing account %s", from)
+	}
+	if account == nil {
+		return nil, fmt.Errorf("Signing account %s does not exist", from)
+	}
+	violation := config.Check(typedDataChainID(typedData))
+	if violation == nil {
+		violation = account.Policy.CheckTypedData(typedData)
+	}
+	if violation != nil {
+		b.Logger().Warn("Typed data rejected by the signing policy", "address", account.Address, "rule", violation.Rule, "error", violation.Message)
+		return violation.Response(), logical.ErrPermissionDenied
+	}
+
+	privateKey, err := b.accountKey(account)
+	if err != nil {
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
+
+	signature, err := crypto.Sign(digest, privateKey)
+	if err != nil {
+		b.Logger().Error("Failed to sign the typed data", "error", err)
+		return nil, err
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"digest":           hexutil.Encode(digest),
+			"domain_separator": hexutil.Encode(domainSeparator),
+			"signature":        hexutil.Encode(signature),
+		},
+	}, nil
+}
+
+// typedDataFromInput assembles the EIP-712 typed data from the request fields
+func typedDataFromInput(data *framework.FieldData, config *Config) (*apitypes.TypedData, error) {
+	input := map[string]interface{}{
+		"types":       data.Get("types"),
+		"primaryType": data.Get("primaryType"),
+		"domain":      data.Get("domain"),
+		"message":     data.Get("message"),
+	}
+	return parseTypedData(input, config)
+}
+
+// parseTypedData converts the generic JSON representation of typed data into the
+// go-ethereum structure. Numbers are converted to strings first, because the
+// go-ethereum encoder only accepts integers as strings or lossy float64 values.
+// The size of the JSON encoding is limited by the maxDataSize of the mount configuration.
+func parseTypedData(input map[string]interface{}, config *Config) (*apitypes.TypedData, error) {
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid typed data: %v", err)
+	}
+	if err := config.checkDataSize("typedData", len(encoded)); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("Invalid typed data: %v", err)
+	}
+	encoded, _ = json.Marshal(numbersToStrings(generic))
+
+	var typedData apitypes.TypedData
+	if err := json.Unmarshal(encoded, &typedData); err != nil {
+		return nil, fmt.Errorf("Invalid typed data: %v", err)
+	}
+	if _, ok := typedData.Types["EIP712Domain"]; !ok {
+		return nil, fmt.Errorf("'types' must include the EIP712Domain type")
+	}
+	if typedData.PrimaryType == "" {
+		return nil, fmt.Errorf("'primaryType' is required")
+	}
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return nil, fmt.Errorf("Primary type %s is not defined in 'types'", typedData.PrimaryType)
+	}
+	return &typedData, nil
+}
+
+// typedDataHash returns the EIP-712 digest keccak256("\x19\x01" || domainSeparator || hashStruct(message))
+// along with the domain separator
+func typedDataHash(typedData *apitypes.TypedData) ([]byte, []byte, error) {
+	domainSeparator, err := typedData.HashStruct("EIP712Domain", typedData.Domain.Map())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to hash the domain: %v", err)
+	}
+	structHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to hash the message: %v", err)
+	}
+	rawData := []byte(fmt.Sprintf("\x19\x01%s%s", string(domainSeparator), string(structHash)))
+	return crypto.Keccak256(rawData), domainSeparator, nil
+}
+
+func numbersToStrings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = numbersToStrings(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = numbersToStrings(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// typedDataChainID returns the chain ID of the domain of the typed data, zero when it has none
+// and the signature is valid on any chain
+func typedDataChainID(typedData *apitypes.TypedData) *big.Int {
+	if typedData.Domain.ChainId == nil {
+		return new(big.Int)
+	}
+	return (*big.Int)(typedData.Domain.ChainId)
+}
@@ -0,0 +1,273 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the example from the EIP-712 specification
+const mailTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "version", "type": "string"},
+      {"name": "chainId", "type": "uint256"},
+      {"name": "verifyingContract", "type": "address"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallet", "type": "address"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person"},
+      {"name": "contents", "type": "string"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {
+    "name": "Ether Mail",
+    "version": "1",
+    "chainId": 1,
+    "verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+  },
+  "message": {
+    "from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+    "to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+    "contents": "Hello, Bob!"
+  }
+}`
+
+// a variation of the example using arrays of nested structs
+const groupMailTypedData = `{
+  "types": {
+    "EIP712Domain": [
+      {"name": "name", "type": "string"},
+      {"name": "chainId", "type": "uint256"}
+    ],
+    "Person": [
+      {"name": "name", "type": "string"},
+      {"name": "wallets", "type": "address[]"}
+    ],
+    "Mail": [
+      {"name": "from", "type": "Person"},
+      {"name": "to", "type": "Person[]"},
+      {"name": "contents", "type": "string"},
+      {"name": "amount", "type": "uint256"}
+    ]
+  },
+  "primaryType": "Mail",
+  "domain": {"name": "Group Mail", "chainId": "0x1"},
+  "message": {
+    "from": {"name": "Cow", "wallets": ["0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"]},
+    "to": [
+      {"name": "Bob", "wallets": ["0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB", "0xB0BdaBea57B0BDABeA57b0bdABEA57b0BDabEa57"]},
+      {"name": "Alice", "wallets": []}
+    ],
+    "contents": "Hello, everyone!",
+    "amount": 123456789012345678901234567890
+  }
+}`
+
+func typedDataRequest(t *testing.T, typedData string) map[string]interface{} {
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(typedData))
+	// the Vault HTTP layer hands numbers to the plugin as json.Number
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return data
+}
+
+func TestSignTypedData(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// the private key of the "Cow" wallet in the EIP-712 example
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"privateKey": hexutil.Encode(crypto.Keccak256([]byte("cow"))),
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	address := resp.Data["address"].(string)
+	assert.Equal("0xcd2a3d9f938e13cd947ec05abc7fe734df8dd826", address)
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+address+"/sign-typed-data")
+	req.Storage = storage
+	req.Data = typedDataRequest(t, mailTypedData)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f", resp.Data["domain_separator"])
+	assert.Equal("0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2", resp.Data["digest"])
+	assert.Equal("0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c", resp.Data["signature"])
+
+	req.Data = typedDataRequest(t, groupMailTypedData)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	digest, _ := hexutil.Decode(resp.Data["digest"].(string))
+	signature, _ := hexutil.Decode(resp.Data["signature"].(string))
+	signature[64] -= 27
+	pubKey, err := crypto.SigToPub(digest, signature)
+	assert.Nil(err)
+	assert.Equal(address, strings.ToLower(crypto.PubkeyToAddress(*pubKey).Hex()))
+}
+
+func TestSignTypedDataFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-typed-data")
+	req.Storage = storage
+
+	req.Data = typedDataRequest(t, mailTypedData)
+	delete(req.Data["types"].(map[string]interface{}), "EIP712Domain")
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("'types' must include the EIP712Domain type", err.Error())
+
+	req.Data = typedDataRequest(t, mailTypedData)
+	req.Data["primaryType"] = "Letter"
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Primary type Letter is not defined in 'types'", err.Error())
+
+	req.Data = typedDataRequest(t, mailTypedData)
+	req.Data["message"].(map[string]interface{})["from"].(map[string]interface{})["wallet"] = "not an address"
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.True(strings.HasPrefix(err.Error(), "Failed to hash the message"))
+
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/0xf809410b0d6f047c603deb311979cd413e025a84/sign-typed-data")
+	req.Storage = storage
+	req.Data = typedDataRequest(t, mailTypedData)
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Signing account 0xf809410b0d6f047c603deb311979cd413e025a84 does not exist", err.Error())
+}
+
+func TestSignTypedDataPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	signTypedData := func(typedData string) (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-typed-data")
+		req.Storage = storage
+		req.Data = typedDataRequest(t, typedData)
+		return b.HandleRequest(context.Background(), req)
+	}
+	setPolicy := func(data map[string]interface{}) {
+		req := logical.TestRequest(t, logical.UpdateOperation, "accounts/"+testAddress+"/policy")
+		req.Storage = storage
+		req.Data = data
+		if _, err := b.HandleRequest(context.Background(), req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	verifyingContract := "0xcccccccccccccccccccccccccccccccccccccccc"
+
+	// the verifying contract must be an allowed destination
+	setPolicy(map[string]interface{}{"allowedTo": allowedTo})
+	resp, err := signTypedData(mailTypedData)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [allowed_to]: verifying contract "+verifyingContract+" is not allowed", resp.Data["error"])
+	setPolicy(map[string]interface{}{"allowedTo": allowedTo + "," + verifyingContract})
+	_, err = signTypedData(mailTypedData)
+	assert.Nil(err)
+
+	// the restrictions on the calls to a contract can't be enforced on typed data
+	var contracts map[string]interface{}
+	json.Unmarshal([]byte(`{"`+verifyingContract+`": {"abi": `+erc20ABI+`, "methods": {"transfer": {}}}}`), &contracts)
+	setPolicy(map[string]interface{}{"contracts": contracts})
+	resp, err = signTypedData(mailTypedData)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [contract_methods]: typed data cannot be signed for "+verifyingContract+", its calls are restricted by the contract policy", resp.Data["error"])
+
+	// typed data such as permits can move funds without a transaction
+	setPolicy(map[string]interface{}{"requiredApprovals": 1, "allowedTo": verifyingContract})
+	resp, err = signTypedData(mailTypedData)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [required_approvals]: typed data cannot be signed by accounts that require approvals", resp.Data["error"])
+}
+
+func TestSignTypedDataChainIDs(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	signTypedData := func(typedData string) (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-typed-data")
+		req.Storage = storage
+		req.Data = typedDataRequest(t, typedData)
+		return b.HandleRequest(context.Background(), req)
+	}
+	update := func(path string, data map[string]interface{}) {
+		req := logical.TestRequest(t, logical.UpdateOperation, path)
+		req.Storage = storage
+		req.Data = data
+		if _, err := b.HandleRequest(context.Background(), req); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	otherChain := strings.Replace(mailTypedData, `"chainId": 1,`, `"chainId": 5,`, 1)
+	unprotected := strings.Replace(strings.Replace(mailTypedData, `"chainId": 1,`, "", 1), `{"name": "chainId", "type": "uint256"},`, "", 1)
+
+	// the chain ID of the domain is checked like the one of transactions
+	update("accounts/"+testAddress+"/policy", map[string]interface{}{"chainIds": "1"})
+	_, err := signTypedData(mailTypedData)
+	assert.Nil(err)
+	resp, err := signTypedData(otherChain)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: chain ID 5 is not allowed by the account policy", resp.Data["error"])
+	resp, err = signTypedData(unprotected)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: a chain ID is required by the account policy", resp.Data["error"])
+
+	update("accounts/"+testAddress+"/policy", map[string]interface{}{"chainIds": "", "forbidUnprotected": true})
+	_, err = signTypedData(otherChain)
+	assert.Nil(err)
+	resp, err = signTypedData(unprotected)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [forbid_unprotected]: transactions without a chain ID are forbidden by the account policy", resp.Data["error"])
+
+	// so do the restrictions of the mount
+	update("accounts/"+testAddress+"/policy", map[string]interface{}{"forbidUnprotected": false})
+	_, err = signTypedData(unprotected)
+	assert.Nil(err)
+	update("config", map[string]interface{}{"chainIds": "1"})
+	resp, err = signTypedData(otherChain)
+	assert.Equal(logical.ErrPermissionDenied, err)
+	assert.Equal("Policy violation [chain_ids]: chain ID 5 is not allowed by the mount configuration", resp.Data["error"])
+}
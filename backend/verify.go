@@ -0,0 +1,149 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func (b *backend) verifySignature(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rawMessage, hasMessage := data.GetOk("message")
+	rawTypedData, hasTypedData := data.GetOk("typedData")
+	rawTransaction, hasTransaction := data.GetOk("transaction")
+	inputs := 0
+	for _, present := range []bool{hasMessage, hasTypedData, hasTransaction} {
+		if present {
+			inputs++
+		}
+	}
+	if inputs != 1 {
+		return nil, fmt.Errorf("Exactly one of 'message', 'typedData' or 'transaction' must be provided")
+	}
+
+	config, err := b.retrieveConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer common.Address
+	if hasTransaction {
+		signer, err = recoverTransactionSender(rawTransaction.(string), config)
+	} else {
+		var hash []byte
+		if hasMessage {
+			message, err := messageFromInput(rawMessage.(string), data.Get("encoding").(string))
+			if err != nil {
+				return nil, err
+			}
+			if err := config.checkDataSize("message", len(message)); err != nil {
+				return nil, err
+			}
+			hash = accounts.TextHash(message)
+		} else {
+			typedData, err := parseTypedData(rawTypedData.(map[string]interface{}), config)
+			if err != nil {
+				return nil, err
+			}
+			if hash, _, err = typedDataHash(typedData); err != nil {
+				return nil, err
+			}
+		}
+		signer, err = recoverSigner(hash, data.Get("signature").(string))
+	}
+	if err != nil {
+		b.Logger().Error("Failed to recover the signer", "error", err)
+		return nil, err
+	}
+
+	address := strings.ToLower(signer.Hex())
+	account, err := b.retrieveAccount(ctx, req, address)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{
+		"address":        address,
+		"account_exists": account != nil,
+	}
+
+	if expected := data.Get("account").(string); expected != "" {
+		expectedAccount, err := b.retrieveAccount(ctx, req, expected)
+		if err != nil {
+			return nil, err
+		}
+		if expectedAccount == nil {
+			return nil, fmt.Errorf("Account %s does not exist", expected)
+		}
+		result["matches"] = expectedAccount.Address == address
+	}
+
+	return &logical.Response{
+		Data: result,
+	}, nil
+}
+
+// recoverSigner returns the address that produced the 65-byte r || s || v signature for the hash
+func recoverSigner(hash []byte, signatureInput string) (common.Address, error) {
+	if signatureInput == "" {
+		return common.Address{}, fmt.Errorf("'signature' is required")
+	}
+	if !strings.HasPrefix(signatureInput, "0x") {
+		signatureInput = "0x" + signatureInput
+	}
+	signature, err := hexutil.Decode(signatureInput)
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("'signature' must be a 65-byte hexidecimal string")
+	}
+	if signature[crypto.RecoveryIDOffset] >= 27 {
+		signature[crypto.RecoveryIDOffset] -= 27
+	}
+	publicKey, err := crypto.SigToPub(hash, signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("Failed to recover the signer: %v", err)
+	}
+	return crypto.PubkeyToAddress(*publicKey), nil
+}
+
+// recoverTransactionSender decodes a signed transaction and returns its sender
+func recoverTransactionSender(rawTransaction string, config *Config) (common.Address, error) {
+	if !strings.HasPrefix(rawTransaction, "0x") {
+		rawTransaction = "0x" + rawTransaction
+	}
+	txBytes, err := hexutil.Decode(rawTransaction)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("Invalid hex value for the 'transaction' field: %v", err)
+	}
+	if err := config.checkDataSize("transaction", len(txBytes)); err != nil {
+		return common.Address{}, err
+	}
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(txBytes); err != nil {
+		return common.Address{}, fmt.Errorf("Failed to decode the transaction: %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), &tx)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("Failed to recover the transaction sender: %v", err)
+	}
+	return sender, nil
+}
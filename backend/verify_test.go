@@ -0,0 +1,184 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+	importTestAccount(t, b, storage)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	otherAddress := resp.Data["address"].(string)
+
+	// personal message
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-message")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	signature := resp.Data["signature"].(string)
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "verify")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"message":   "hello",
+		"signature": signature,
+		"account":   testAddress,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Equal(true, resp.Data["account_exists"])
+	assert.Equal(true, resp.Data["matches"])
+
+	req.Data["account"] = otherAddress
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(false, resp.Data["matches"])
+
+	// a different message recovers a different address
+	req.Data = map[string]interface{}{
+		"message":   "goodbye",
+		"signature": signature,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.NotEqual(testAddress, resp.Data["address"])
+	assert.Equal(false, resp.Data["account_exists"])
+	assert.Nil(resp.Data["matches"])
+
+	// typed data
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign-typed-data")
+	req.Storage = storage
+	req.Data = typedDataRequest(t, mailTypedData)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "verify")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"typedData": typedDataRequest(t, mailTypedData),
+		"signature": resp.Data["signature"],
+		"account":   testAddress,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(true, resp.Data["matches"])
+
+	// signed transactions, legacy and typed
+	for _, txData := range []map[string]interface{}{
+		{"data": "0x", "to": testAddress},
+		{"data": "0x", "to": testAddress, "chainId": "12345"},
+		{"data": "0x", "to": testAddress, "chainId": "12345", "maxFeePerGas": "100"},
+	} {
+		req = logical.TestRequest(t, logical.CreateOperation, "accounts/"+testAddress+"/sign")
+		req.Storage = storage
+		req.Data = txData
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		req = logical.TestRequest(t, logical.UpdateOperation, "verify")
+		req.Storage = storage
+		req.Data = map[string]interface{}{
+			"transaction": resp.Data["signed_transaction"],
+			"account":     testAddress,
+		}
+		resp, err = b.HandleRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		assert.Equal(testAddress, resp.Data["address"])
+		assert.Equal(true, resp.Data["matches"])
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "verify")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"signature": "0x00",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Exactly one of 'message', 'typedData' or 'transaction' must be provided", err.Error())
+
+	req.Data = map[string]interface{}{
+		"message":     "hello",
+		"transaction": "0x00",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Exactly one of 'message', 'typedData' or 'transaction' must be provided", err.Error())
+
+	req.Data = map[string]interface{}{
+		"message": "hello",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'signature' is required", err.Error())
+
+	req.Data = map[string]interface{}{
+		"message":   "hello",
+		"signature": "0xabcd",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("'signature' must be a 65-byte hexidecimal string", err.Error())
+
+	req.Data = map[string]interface{}{
+		"transaction": "0xabcd",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Contains(err.Error(), "Failed to decode the transaction")
+
+	req.Data = map[string]interface{}{
+		"message":   "hello",
+		"signature": "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c",
+		"account":   testAddress,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+testAddress+" does not exist", err.Error())
+}
@@ -0,0 +1,343 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// Wallet is a BIP-32 hierarchical deterministic wallet, with the seed computed from a BIP-39 mnemonic
+type Wallet struct {
+	Name      string            `json:"name"`
+	Seed      string            `json:"seed"`
+	NextIndex uint32            `json:"next_index"`
+	Accounts  map[string]string `json:"accounts"`
+}
+
+func (b *backend) listWallets(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	vals, err := req.Storage.List(ctx, "wallets/")
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the list of wallets", "error", err)
+		return nil, err
+	}
+
+	return logical.ListResponse(vals), nil
+}
+
+func (b *backend) createWallet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	if !accountNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("Invalid wallet name %s, must only contain letters, digits, '_', '-' and '.'", name)
+	}
+
+	mnemonic := strings.Join(strings.Fields(data.Get("mnemonic").(string)), " ")
+	if mnemonic != "" {
+		config, err := b.retrieveConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if !config.AllowImport {
+			return nil, fmt.Errorf("Importing mnemonics is disabled by the mount configuration")
+		}
+	} else {
+		entropy, err := bip39.NewEntropy(data.Get("bitSize").(int))
+		if err != nil {
+			b.Logger().Error("Failed to generate the mnemonic entropy", "error", err)
+			return nil, fmt.Errorf("Failed to generate the mnemonic: %v", err)
+		}
+		mnemonic, _ = bip39.NewMnemonic(entropy)
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, data.Get("passphrase").(string))
+	if err != nil {
+		b.Logger().Error("Invalid mnemonic", "error", err)
+		return nil, fmt.Errorf("Invalid mnemonic: %v", err)
+	}
+
+	lock := locksutil.LockForKey(b.locks, "wallets/"+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	existing, err := b.retrieveWallet(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Wallet %s already exists", name)
+	}
+
+	wallet := &Wallet{
+		Name:     name,
+		Seed:     hexutil.Encode(seed),
+		Accounts: map[string]string{},
+	}
+	if err := b.saveWallet(ctx, req, wallet); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: walletData(wallet),
+	}, nil
+}
+
+func (b *backend) readWallet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	wallet, err := b.retrieveWallet(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("Wallet does not exist")
+	}
+
+	return &logical.Response{
+		Data: walletData(wallet),
+	}, nil
+}
+
+func (b *backend) deleteWallet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	lock := locksutil.LockForKey(b.locks, "wallets/"+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("wallets/%s", name)); err != nil {
+		b.Logger().Error("Failed to delete the wallet from storage", "name", name, "error", err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *backend) listWalletAccounts(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+	wallet, err := b.retrieveWallet(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("Wallet does not exist")
+	}
+
+	addresses := make([]string, 0, len(wallet.Accounts))
+	for _, address := range wallet.Accounts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return logical.ListResponse(addresses), nil
+}
+
+func (b *backend) deriveWalletAccount(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	name := data.Get("name").(string)
+
+	lock := locksutil.LockForKey(b.locks, "wallets/"+name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	wallet, err := b.retrieveWallet(ctx, req, name)
+	if err != nil {
+		return nil, err
+	}
+	if wallet == nil {
+		return nil, fmt.Errorf("Wallet does not exist")
+	}
+
+	rawIndex, hasIndex := data.GetOk("index")
+	rawPath, hasPath := data.GetOk("path")
+	var path accounts.DerivationPath
+	var nextIndex uint32
+	switch {
+	case hasIndex && hasPath:
+		return nil, fmt.Errorf("Only one of 'index' or 'path' can be provided")
+	case hasPath:
+		path, err = accounts.ParseDerivationPath(rawPath.(string))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid derivation path: %v", err)
+		}
+		nextIndex = wallet.NextIndex
+	default:
+		index := wallet.NextIndex
+		if hasIndex {
+			if rawIndex.(int) < 0 || rawIndex.(int) >= 0x80000000 {
+				return nil, fmt.Errorf("Invalid index %d, must be between 0 and 2147483647", rawIndex.(int))
+			}
+			index = uint32(rawIndex.(int))
+		}
+		path = make(accounts.DerivationPath, len(accounts.DefaultBaseDerivationPath))
+		copy(path, accounts.DefaultBaseDerivationPath)
+		path[len(path)-1] = index
+		nextIndex = wallet.NextIndex
+		if index >= nextIndex {
+			nextIndex = index + 1
+		}
+	}
+
+	seed, err := hexutil.Decode(wallet.Seed)
+	if err != nil {
+		b.Logger().Error("Error decoding the retrieved wallet seed", "error", err)
+		return nil, fmt.Errorf("Error decoding the retrieved wallet seed")
+	}
+	privateKey, err := deriveKey(seed, path)
+	if err != nil {
+		b.Logger().Error("Failed to derive the account key", "path", path.String(), "error", err)
+		return nil, err
+	}
+	defer ZeroKey(privateKey)
+
+	account := accountFromKey(privateKey)
+	exportable := data.Get("exportable").(bool)
+	account.Exportable = &exportable
+	account.setCreator(req)
+	defer b.lockAccount(account.Address)()
+	existing, err := b.retrieveAccount(ctx, req, account.Address)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// deriving the same path again keeps the existing account and its settings
+		account = existing
+	} else if exportable {
+		// the key of an account that was not exportable, deleted or purged since, doesn't
+		// become exportable by deriving it again
+		unexportable, err := b.wasUnexportable(ctx, req.Storage, account.Address)
+		if err != nil {
+			return nil, err
+		}
+		if unexportable {
+			return nil, fmt.Errorf("The key of account %s is not exportable, it cannot be derived again as exportable", account.Address)
+		}
+	}
+	account.Wallet = wallet.Name
+	account.DerivationPath = path.String()
+
+	if accountName := data.Get("accountName").(string); accountName != "" {
+		defer b.lockAccountName(accountName)()
+		if err := b.setAccountName(ctx, req.Storage, account, accountName); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.saveAccount(ctx, req, account); err != nil {
+		return nil, err
+	}
+
+	wallet.Accounts[path.String()] = account.Address
+	wallet.NextIndex = nextIndex
+	if err := b.saveWallet(ctx, req, wallet); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: accountData(account),
+	}, nil
+}
+
+func (b *backend) retrieveWallet(ctx context.Context, req *logical.Request, name string) (*Wallet, error) {
+	path := fmt.Sprintf("wallets/%s", name)
+	entry, err := req.Storage.Get(ctx, path)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the wallet by name", "path", path, "error", err)
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	var wallet Wallet
+	if err := entry.DecodeJSON(&wallet); err != nil {
+		return nil, err
+	}
+	if wallet.Accounts == nil {
+		wallet.Accounts = map[string]string{}
+	}
+	return &wallet, nil
+}
+
+func (b *backend) saveWallet(ctx context.Context, req *logical.Request, wallet *Wallet) error {
+	entry, _ := logical.StorageEntryJSON(fmt.Sprintf("wallets/%s", wallet.Name), wallet)
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the wallet to storage", "name", wallet.Name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// walletData returns the public details of the wallet, leaving out the seed
+func walletData(wallet *Wallet) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       wallet.Name,
+		"next_index": wallet.NextIndex,
+		"accounts":   wallet.Accounts,
+	}
+}
+
+// deriveKey derives the BIP-32 child private key at the path from the seed
+func deriveKey(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	curveOrder := crypto.S256().Params().N
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := new(big.Int).SetBytes(sum[:32]), sum[32:]
+	if key.Sign() == 0 || key.Cmp(curveOrder) >= 0 {
+		return nil, fmt.Errorf("The seed does not produce a valid master key")
+	}
+
+	for _, index := range path {
+		var data []byte
+		if index >= 0x80000000 {
+			// hardened child: 0x00 || ser256(k) || ser32(i)
+			data = append([]byte{0}, math.PaddedBigBytes(key, 32)...)
+		} else {
+			// normal child: serP(point(k)) || ser32(i)
+			parent, err := crypto.ToECDSA(math.PaddedBigBytes(key, 32))
+			if err != nil {
+				return nil, err
+			}
+			data = crypto.CompressPubkey(&parent.PublicKey)
+			ZeroKey(parent)
+		}
+		data = append(data, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(data[len(data)-4:], index)
+
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(curveOrder) >= 0 {
+			return nil, fmt.Errorf("Invalid child key at index %d, use the next index", index)
+		}
+		key = tweak.Add(tweak, key).Mod(tweak, curveOrder)
+		if key.Sign() == 0 {
+			return nil, fmt.Errorf("Invalid child key at index %d, use the next index", index)
+		}
+		chainCode = sum[32:]
+	}
+	return crypto.ToECDSA(math.PaddedBigBytes(key, 32))
+}
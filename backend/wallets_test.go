@@ -0,0 +1,314 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func TestWallets(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// import a wallet from a mnemonic
+	req := logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":     "deposits",
+		"mnemonic": testMnemonic,
+	}
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("deposits", resp.Data["name"])
+	assert.Nil(resp.Data["seed"])
+
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Wallet deposits already exists", err.Error())
+
+	// generate a wallet
+	req.Data = map[string]interface{}{
+		"name":    "generated",
+		"bitSize": 128,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.ListOperation, "wallets")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.ElementsMatch([]string{"deposits", "generated"}, resp.Data["keys"])
+
+	// derive the first accounts at m/44'/60'/0'/0/i
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets/deposits/accounts")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x9858effd232b4033e47d90003d41ec34ecaeda94", resp.Data["address"])
+	assert.Equal("m/44'/60'/0'/0/0", resp.Data["derivation_path"])
+	assert.Equal("deposits", resp.Data["wallet"])
+
+	req.Data = map[string]interface{}{
+		"accountName": "deposit-1",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x6fac4d18c912343bf86fa7049364dd4e424ab9c0", resp.Data["address"])
+	assert.Equal("m/44'/60'/0'/0/1", resp.Data["derivation_path"])
+	assert.Equal("deposit-1", resp.Data["name"])
+
+	// explicit index and path
+	req.Data = map[string]interface{}{
+		"index": 5,
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("m/44'/60'/0'/0/5", resp.Data["derivation_path"])
+
+	req.Data = map[string]interface{}{
+		"path": "m/44'/60'/0'/0/1",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x6fac4d18c912343bf86fa7049364dd4e424ab9c0", resp.Data["address"])
+	assert.Equal("deposit-1", resp.Data["name"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "wallets/deposits")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(uint32(6), resp.Data["next_index"])
+	assert.Equal(3, len(resp.Data["accounts"].(map[string]string)))
+
+	req = logical.TestRequest(t, logical.ListOperation, "wallets/deposits/accounts")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(3, len(resp.Data["keys"].([]string)))
+
+	// derived accounts are regular accounts
+	req = logical.TestRequest(t, logical.CreateOperation, "accounts/deposit-1/sign")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"data":    "0x",
+		"to":      "0xf809410b0d6f047c603deb311979cd413e025a84",
+		"chainId": "1",
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	txBytes, _ := hexutil.Decode(resp.Data["signed_transaction"].(string))
+	var tx types.Transaction
+	if err := tx.UnmarshalBinary(txBytes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sender, _ := types.Sender(types.LatestSignerForChainID(tx.ChainId()), &tx)
+	assert.Equal("0x6fac4d18c912343bf86fa7049364dd4e424ab9c0", strings.ToLower(sender.Hex()))
+
+	// deleting the wallet keeps the derived accounts
+	req = logical.TestRequest(t, logical.DeleteOperation, "wallets/deposits")
+	req.Storage = storage
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req = logical.TestRequest(t, logical.ReadOperation, "accounts/deposit-1")
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal("0x6fac4d18c912343bf86fa7049364dd4e424ab9c0", resp.Data["address"])
+}
+
+func TestWalletsWithPassphrase(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":       "protected",
+		"mnemonic":   testMnemonic,
+		"passphrase": "TREZOR",
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets/protected/accounts")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// the passphrase changes the seed, and therefore the derived addresses
+	assert.NotEqual("0x9858effd232b4033e47d90003d41ec34ecaeda94", resp.Data["address"])
+}
+
+func TestWalletsFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":     "deposits",
+		"mnemonic": "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+	}
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.True(strings.HasPrefix(err.Error(), "Invalid mnemonic"))
+
+	req.Data = map[string]interface{}{
+		"name":    "deposits",
+		"bitSize": 100,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.True(strings.HasPrefix(err.Error(), "Failed to generate the mnemonic"))
+
+	req.Data = map[string]interface{}{
+		"name": "bad name",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid wallet name bad name, must only contain letters, digits, '_', '-' and '.'", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets/missing/accounts")
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Wallet does not exist", err.Error())
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":     "deposits",
+		"mnemonic": testMnemonic,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	req = logical.TestRequest(t, logical.UpdateOperation, "wallets/deposits/accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"index": 1,
+		"path":  "m/44'/60'/0'/0/1",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Only one of 'index' or 'path' can be provided", err.Error())
+
+	req.Data = map[string]interface{}{
+		"path": "m/44'/x",
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.True(strings.HasPrefix(err.Error(), "Invalid derivation path"))
+
+	req.Data = map[string]interface{}{
+		"index": -1,
+	}
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Invalid index -1, must be between 0 and 2147483647", err.Error())
+}
+
+func TestWalletsRederiveDeleted(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "wallets")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"name":     "deposits",
+		"mnemonic": testMnemonic,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	derive := func(exportable bool) (*logical.Response, error) {
+		req := logical.TestRequest(t, logical.UpdateOperation, "wallets/deposits/accounts")
+		req.Storage = storage
+		req.Data = map[string]interface{}{
+			"index":      0,
+			"exportable": exportable,
+		}
+		return b.HandleRequest(context.Background(), req)
+	}
+	resp, err := derive(false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	address := resp.Data["address"].(string)
+	notExportable := "The key of account " + address + " is not exportable, it cannot be derived again as exportable"
+
+	req = logical.TestRequest(t, logical.DeleteOperation, "accounts/"+address)
+	req.Storage = storage
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// deriving the deleted account again can't make it exportable
+	_, err = derive(true)
+	assert.Equal(notExportable, err.Error())
+
+	// nor once it's purged
+	req = logical.TestRequest(t, logical.UpdateOperation, "deleted/"+address+"/purge")
+	req.Storage = storage
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	deleted, _ := b.(*backend).retrieveDeletedAccount(context.Background(), storage, address)
+	assert.Nil(deleted)
+	_, err = derive(true)
+	assert.Equal(notExportable, err.Error())
+
+	resp, err = derive(false)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(address, resp.Data["address"])
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+address)
+	req.Storage = storage
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Account "+address+" is not exportable", err.Error())
+}
@@ -0,0 +1,186 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	wrappingKeyPath = "wrapping_key"
+	wrappingKeyBits = 4096
+)
+
+// kwpIV is the alternative initial value of AES key wrap with padding, from RFC 5649
+var kwpIV = []byte{0xa6, 0x59, 0x59, 0xa6}
+
+// WrappingKey is the RSA key the keys to import are encrypted to, generated on first use
+type WrappingKey struct {
+	Key string `json:"key"`
+}
+
+func (b *backend) readWrappingKey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	wrappingKey, err := b.retrieveWrappingKey(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&wrappingKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		},
+	}, nil
+}
+
+// retrieveWrappingKey returns the wrapping key of the mount, generating it if needed
+func (b *backend) retrieveWrappingKey(ctx context.Context, storage logical.Storage) (*rsa.PrivateKey, error) {
+	lock := locksutil.LockForKey(b.locks, wrappingKeyPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := storage.Get(ctx, wrappingKeyPath)
+	if err != nil {
+		b.Logger().Error("Failed to retrieve the wrapping key", "error", err)
+		return nil, err
+	}
+	if entry != nil {
+		var stored WrappingKey
+		if err := entry.DecodeJSON(&stored); err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode([]byte(stored.Key))
+		if block == nil {
+			return nil, fmt.Errorf("Failed to decode the stored wrapping key")
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	wrappingKey, err := rsa.GenerateKey(rand.Reader, wrappingKeyBits)
+	if err != nil {
+		b.Logger().Error("Failed to generate the wrapping key", "error", err)
+		return nil, err
+	}
+	stored := &WrappingKey{
+		Key: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(wrappingKey)})),
+	}
+	entry, _ = logical.StorageEntryJSON(wrappingKeyPath, stored)
+	if err := storage.Put(ctx, entry); err != nil {
+		b.Logger().Error("Failed to save the wrapping key", "error", err)
+		return nil, err
+	}
+	return wrappingKey, nil
+}
+
+// unwrapImportedKey decrypts a private key wrapped like the keys imported into Vault transit: the
+// RSA-OAEP (SHA-256) ciphertext of an ephemeral AES key, followed by the raw private key wrapped
+// with that AES key using AES-KWP
+func (b *backend) unwrapImportedKey(ctx context.Context, storage logical.Storage, input string) (*ecdsa.PrivateKey, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return nil, fmt.Errorf("'wrappedKey' must be base64 encoded")
+	}
+	wrappingKey, err := b.retrieveWrappingKey(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) <= wrappingKey.Size() {
+		return nil, fmt.Errorf("'wrappedKey' is too short")
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, wrappingKey, wrapped[:wrappingKey.Size()], nil)
+	if err != nil {
+		b.Logger().Error("Failed to decrypt the ephemeral AES key", "error", err)
+		return nil, fmt.Errorf("Failed to decrypt the ephemeral AES key of 'wrappedKey'")
+	}
+	defer zeroBytes(aesKey)
+	keyBytes, err := kwpUnwrap(aesKey, wrapped[wrappingKey.Size():])
+	if err != nil {
+		b.Logger().Error("Failed to unwrap the imported key", "error", err)
+		return nil, fmt.Errorf("Failed to unwrap the private key of 'wrappedKey'")
+	}
+	defer zeroBytes(keyBytes)
+	privateKey, err := crypto.ToECDSA(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("'wrappedKey' does not contain a valid 32-byte private key")
+	}
+	return privateKey, nil
+}
+
+// kwpUnwrap implements the AES key unwrap with padding algorithm of RFC 5649
+func kwpUnwrap(kek, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 16 || len(ciphertext)%8 != 0 {
+		return nil, fmt.Errorf("Invalid length of the wrapped key")
+	}
+	cipher, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(ciphertext)/8 - 1
+	block := make([]byte, 16)
+	a := make([]byte, 8)
+	r := make([]byte, len(ciphertext)-8)
+	if n == 1 {
+		// a single 64-bit block is encrypted with AES directly
+		cipher.Decrypt(block, ciphertext)
+		copy(a, block[:8])
+		copy(r, block[8:])
+	} else {
+		copy(a, ciphertext[:8])
+		copy(r, ciphertext[8:])
+		for j := 5; j >= 0; j-- {
+			for i := n; i >= 1; i-- {
+				t := uint64(n*j + i)
+				binary.BigEndian.PutUint64(block[:8], binary.BigEndian.Uint64(a)^t)
+				copy(block[8:], r[(i-1)*8:i*8])
+				cipher.Decrypt(block, block)
+				copy(a, block[:8])
+				copy(r[(i-1)*8:i*8], block[8:])
+			}
+		}
+	}
+
+	if !bytes.Equal(a[:4], kwpIV) {
+		return nil, fmt.Errorf("Integrity check of the wrapped key failed")
+	}
+	length := int(binary.BigEndian.Uint32(a[4:]))
+	if length > len(r) || length <= len(r)-8 {
+		return nil, fmt.Errorf("Integrity check of the wrapped key failed")
+	}
+	for _, padding := range r[length:] {
+		if padding != 0 {
+			return nil, fmt.Errorf("Integrity check of the wrapped key failed")
+		}
+	}
+	return r[:length], nil
+}
@@ -0,0 +1,244 @@
+// Copyright © 2020 Kaleido
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// kwpWrap implements the AES key wrap with padding algorithm of RFC 5649, as done by the clients
+func kwpWrap(kek, plaintext []byte) []byte {
+	cipher, _ := aes.NewCipher(kek)
+	padded := make([]byte, (len(plaintext)+7)/8*8)
+	copy(padded, plaintext)
+	a := make([]byte, 8)
+	copy(a, kwpIV)
+	binary.BigEndian.PutUint32(a[4:], uint32(len(plaintext)))
+
+	block := make([]byte, 16)
+	if len(padded) == 8 {
+		copy(block, a)
+		copy(block[8:], padded)
+		cipher.Encrypt(block, block)
+		return block
+	}
+	n := len(padded) / 8
+	for j := 0; j <= 5; j++ {
+		for i := 1; i <= n; i++ {
+			copy(block, a)
+			copy(block[8:], padded[(i-1)*8:i*8])
+			cipher.Encrypt(block, block)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(block[:8])^uint64(n*j+i))
+			copy(padded[(i-1)*8:i*8], block[8:])
+		}
+	}
+	return append(a, padded...)
+}
+
+func wrapTestKey(t *testing.T, b logical.Backend, storage logical.Storage, privateKey []byte) string {
+	req := logical.TestRequest(t, logical.ReadOperation, "wrapping_key")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	block, _ := pem.Decode([]byte(resp.Data["public_key"].(string)))
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	aesKey := make([]byte, 32)
+	_, _ = rand.Read(aesKey)
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey.(*rsa.PublicKey), aesKey, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(append(encryptedKey, kwpWrap(aesKey, privateKey)...))
+}
+
+func TestKWP(t *testing.T) {
+	assert := assert.New(t)
+
+	// test vectors from RFC 5649
+	kek, _ := hex.DecodeString("5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8")
+	vectors := map[string]string{
+		"c37b7e6492584340bed12207808941155068f738": "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a",
+		"466f7250617369": "afbeb0f07dfbf5419200f2ccb50bb24f",
+	}
+	for key, wrapped := range vectors {
+		keyBytes, _ := hex.DecodeString(key)
+		wrappedBytes, _ := hex.DecodeString(wrapped)
+		assert.Equal(wrappedBytes, kwpWrap(kek, keyBytes))
+		unwrapped, err := kwpUnwrap(kek, wrappedBytes)
+		assert.Nil(err)
+		assert.Equal(keyBytes, unwrapped)
+	}
+
+	wrapped, _ := hex.DecodeString("138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a")
+	wrapped[0]++
+	_, err := kwpUnwrap(kek, wrapped)
+	assert.Equal("Integrity check of the wrapped key failed", err.Error())
+	_, err = kwpUnwrap(kek, wrapped[:12])
+	assert.Equal("Invalid length of the wrapped key", err.Error())
+}
+
+func TestImportWrappedKey(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	// the wrapping key is only generated once
+	req := logical.TestRequest(t, logical.ReadOperation, "wrapping_key")
+	req.Storage = storage
+	resp, err := b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	publicKey := resp.Data["public_key"].(string)
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(publicKey, resp.Data["public_key"])
+	block, _ := pem.Decode([]byte(publicKey))
+	parsed, _ := x509.ParsePKIXPublicKey(block.Bytes)
+	assert.Equal(4096, parsed.(*rsa.PublicKey).N.BitLen())
+
+	keyBytes, _ := hex.DecodeString(testPrivateKey)
+	req = logical.TestRequest(t, logical.UpdateOperation, "accounts")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"wrappedKey": wrapTestKey(t, b, storage, keyBytes),
+	}
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testAddress, resp.Data["address"])
+	assert.Equal(true, resp.Data["exportable"])
+
+	req = logical.TestRequest(t, logical.ReadOperation, "export/accounts/"+testAddress)
+	req.Storage = storage
+	resp, err = b.HandleRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	assert.Equal(testPrivateKey, resp.Data["privateKey"])
+}
+
+func TestImportWrappedKeyFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	b, storage := getBackend(t)
+
+	importWrapped := func(data map[string]interface{}) error {
+		req := logical.TestRequest(t, logical.UpdateOperation, "accounts")
+		req.Storage = storage
+		req.Data = data
+		_, err := b.HandleRequest(context.Background(), req)
+		return err
+	}
+
+	keyBytes, _ := hex.DecodeString(testPrivateKey)
+	wrapped := wrapTestKey(t, b, storage, keyBytes)
+
+	err := importWrapped(map[string]interface{}{
+		"wrappedKey": wrapped,
+		"privateKey": testPrivateKey,
+	})
+	assert.Equal("'wrappedKey' cannot be combined with 'privateKey' or 'keystore'", err.Error())
+
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": "not base64!",
+	})
+	assert.Equal("'wrappedKey' must be base64 encoded", err.Error())
+
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": base64.StdEncoding.EncodeToString(make([]byte, 512)),
+	})
+	assert.Equal("'wrappedKey' is too short", err.Error())
+
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": base64.StdEncoding.EncodeToString(make([]byte, 552)),
+	})
+	assert.Equal("Failed to decrypt the ephemeral AES key of 'wrappedKey'", err.Error())
+
+	raw, _ := base64.StdEncoding.DecodeString(wrapped)
+	raw[len(raw)-1]++
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": base64.StdEncoding.EncodeToString(raw),
+	})
+	assert.Equal("Failed to unwrap the private key of 'wrappedKey'", err.Error())
+
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": wrapTestKey(t, b, storage, keyBytes[:16]),
+	})
+	assert.Equal("'wrappedKey' does not contain a valid 32-byte private key", err.Error())
+
+	req := logical.TestRequest(t, logical.UpdateOperation, "config")
+	req.Storage = storage
+	req.Data = map[string]interface{}{
+		"allowImport": false,
+	}
+	if _, err := b.HandleRequest(context.Background(), req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": wrapped,
+	})
+	assert.Equal("Importing private keys is disabled by the mount configuration", err.Error())
+
+	// nothing is decrypted when imports are disabled
+	err = importWrapped(map[string]interface{}{
+		"wrappedKey": base64.StdEncoding.EncodeToString(raw),
+	})
+	assert.Equal("Importing private keys is disabled by the mount configuration", err.Error())
+	err = importWrapped(map[string]interface{}{
+		"keystore": `{"crypto": {}}`,
+		"password": "secret",
+	})
+	assert.Equal("Importing private keys is disabled by the mount configuration", err.Error())
+}
+
+func TestWrappingKeyStorageFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	b, _ := getBackend(t)
+
+	req := logical.TestRequest(t, logical.ReadOperation, "wrapping_key")
+	sm := newStorageMock()
+	req.Storage = sm
+	_, err := b.HandleRequest(context.Background(), req)
+	assert.Equal("Bang for Get!", err.Error())
+
+	sm.switches[1] = 1
+	_, err = b.HandleRequest(context.Background(), req)
+	assert.Equal("Bang for Put!", err.Error())
+}
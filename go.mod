@@ -4,9 +4,12 @@ go 1.16
 
 require (
 	github.com/ethereum/go-ethereum v1.10.17
+	github.com/google/uuid v1.2.0
 	github.com/hashicorp/go-hclog v0.8.0
+	github.com/hashicorp/go-multierror v1.0.0
 	github.com/hashicorp/vault/api v1.0.4
 	github.com/hashicorp/vault/sdk v0.1.13
 	github.com/stretchr/testify v1.7.0
+	github.com/tyler-smith/go-bip39 v1.1.0
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )